@@ -5,6 +5,7 @@ go 1.13
 require (
 	github.com/gorilla/mux v1.7.3
 	github.com/gorilla/websocket v1.4.1
+	golang.org/x/crypto v0.0.0-20191011191535-87dc89f01550
 	golang.org/x/net v0.0.0-20190827160401-ba9fcec4b297
 	golang.org/x/text v0.3.2
 	gopkg.in/yaml.v3 v3.0.0-20190904155537-35294daf730c
@@ -1,13 +1,35 @@
 package main
 
 import (
+	"flag"
+	"fmt"
 	"log"
+	"os"
+	"time"
 
 	"github.com/ssetin/PenguinCast/src/ice"
 )
 
 func main() {
-	server, err := ice.NewServer()
+	if len(os.Args) > 1 && os.Args[1] == "sign" {
+		sign(os.Args[2:])
+		return
+	}
+
+	configFile := flag.String("config", "config.yaml", "path to config file")
+	checkConfig := flag.Bool("check-config", false, "validate config file and exit")
+	flag.Parse()
+
+	if *checkConfig {
+		if err := ice.CheckConfig(*configFile); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		fmt.Println(*configFile + " is OK")
+		return
+	}
+
+	server, err := ice.NewServer(*configFile)
 
 	if err != nil {
 		log.Println(err.Error())
@@ -17,3 +39,24 @@ func main() {
 
 	server.Start()
 }
+
+// sign - prints signed listener url
+func sign(args []string) {
+	flags := flag.NewFlagSet("sign", flag.ExitOnError)
+	streamURL := flags.String("url", "", "stream url, e.g. http://localhost:8008/RockRadio96")
+	secret := flags.String("secret", "", "SignSecret of the mount")
+	ttl := flags.Duration("ttl", time.Hour, "time the url is valid for")
+	ip := flags.String("ip", "", "client ip, if mount has SignBindIP option")
+	_ = flags.Parse(args)
+
+	if *streamURL == "" || *secret == "" {
+		flags.Usage()
+		os.Exit(2)
+	}
+	signed, err := ice.SignURL(*streamURL, *secret, time.Now().Add(*ttl), *ip)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	fmt.Println(signed)
+}
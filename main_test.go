@@ -36,7 +36,7 @@ func init() {
 }
 
 func startServer() {
-	server, err := ice.NewServer()
+	server, err := ice.NewServer("config.yaml")
 	if err != nil {
 		log.Println(err.Error())
 		return
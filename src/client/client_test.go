@@ -0,0 +1,120 @@
+// Copyright 2019 Setin Sergei
+// Licensed under the Apache License, Version 2.0 (the "License")
+
+package iceclient
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testUpstream - starts server, which serves every connection by serve after reading request headers.
+// Listener has to be closed by caller
+func testUpstream(t *testing.T, serve func(conn net.Conn)) (net.Listener, string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				if _, err := http.ReadRequest(bufio.NewReader(conn)); err == nil {
+					serve(conn)
+				}
+			}()
+		}
+	}()
+	return ln, "http://" + ln.Addr().String() + "/live"
+}
+
+func TestMetaReaderThroughput(t *testing.T) {
+	const metaInt = 1000
+	var audio, stream bytes.Buffer
+	stream.WriteString("HTTP/1.0 200 OK\r\nContent-Type: audio/mpeg\r\nIcy-Metaint: 1000\r\n\r\n")
+	meta := make([]byte, 33)
+	meta[0] = 2
+	copy(meta[1:], "StreamTitle='Artist - Song';")
+	// 50 seconds of stream with metaInt bytes per second are sent at once
+	for i := 0; i < 50; i++ {
+		block := bytes.Repeat([]byte{byte(i)}, metaInt)
+		audio.Write(block)
+		stream.Write(block)
+		if i%10 == 0 {
+			stream.Write(meta)
+		} else {
+			stream.WriteByte(0)
+		}
+	}
+	ln, url := testUpstream(t, func(conn net.Conn) {
+		_, _ = conn.Write(stream.Bytes())
+	})
+	defer ln.Close()
+
+	var title string
+	p := &PenguinClient{}
+	if err := p.InitURL(url, true); err != nil {
+		t.Fatal(err)
+	}
+	p.OnMeta(func(t string) { title = t })
+	if err := p.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	// let the whole stream arrive
+	time.Sleep(100 * time.Millisecond)
+
+	var received []byte
+	buff := make([]byte, 64*1024)
+	reads := 0
+	for {
+		n, err := p.Read(buff)
+		received = append(received, buff[:n]...)
+		if err != nil {
+			break
+		}
+		reads++
+	}
+	if !bytes.Equal(received, audio.Bytes()) || title != "Artist - Song" {
+		t.Errorf("got %d bytes of %d, title %q", len(received), audio.Len(), title)
+	}
+	if reads > 3 {
+		t.Errorf("stream received at once is read by %d calls", reads)
+	}
+}
+
+func TestRelayBackoff(t *testing.T) {
+	var connections int32
+	ln, url := testUpstream(t, func(conn net.Conn) {
+		atomic.AddInt32(&connections, 1)
+		_, _ = conn.Write([]byte("HTTP/1.0 200 OK\r\nContent-Type: audio/mpeg\r\n\r\nstream"))
+		time.Sleep(time.Second)
+	})
+	defer ln.Close()
+
+	p := &PenguinClient{}
+	if err := p.InitURL(url, false); err != nil {
+		t.Fatal(err)
+	}
+	stop := make(chan struct{})
+	time.AfterFunc(300*time.Millisecond, func() { close(stop) })
+	// mount is busy, so stream fails at once without reading
+	p.Relay(stop, 10*time.Millisecond, 80*time.Millisecond, func() error {
+		return errors.New("mount is busy by another source")
+	}, func(error) {})
+
+	// delays are 10, 20, 40, 80, 80 ms
+	if n := atomic.LoadInt32(&connections); n > 8 {
+		t.Errorf("upstream is connected %d times in 300 ms", n)
+	}
+}
@@ -5,38 +5,50 @@ package iceclient
 
 import (
 	"bufio"
+	"errors"
 	"net/textproto"
+	"strconv"
 	"strings"
 )
 
 type httpHeaders map[string]string
 
-func processHTTPHeaders(reader *bufio.Reader) (httpHeaders, error) {
+// processHTTPHeaders - reads status line and headers of the server answer, returns status code and headers.
+// Header names are in canonical form (Content-Type, Icy-Metaint)
+func processHTTPHeaders(reader *bufio.Reader) (int, httpHeaders, error) {
 	headers := make(map[string]string)
 	tp := textproto.NewReader(reader)
 
+	status, err := tp.ReadLine()
+	if err != nil {
+		return 0, nil, err
+	}
+	// HTTP/1.0 200 OK or ICY 200 OK
+	params := strings.Fields(status)
+	if len(params) < 2 {
+		return 0, nil, errors.New("wrong status line " + status)
+	}
+	code, err := strconv.Atoi(params[1])
+	if err != nil {
+		return 0, nil, errors.New("wrong status line " + status)
+	}
+
 	for {
 		line, err := tp.ReadLine()
 		if err != nil {
-			return nil, err
+			return 0, nil, err
 		}
 		if line == "" {
 			break
 		}
-		params := strings.Split(line, ":")
-		if len(params) >= 3 {
-			// field Address could contain few addresses delimited by [:] in case of several candidates
-			// for now i process only one
-			if params[0] == "Address" {
-				headers["Address"] = strings.TrimSpace(params[1]) + ":" + strings.TrimSpace(params[2])
-			}
-		} else if len(params) == 2 {
-			if params[0] == "X-Audiocast-Bitrate" {
-				headers["X-Audiocast-Bitrate"] = strings.TrimSpace(params[1])
-			}
+		// field Address could contain few addresses delimited by [:] in case of several candidates,
+		// so only the name is split off
+		idx := strings.Index(line, ":")
+		if idx <= 0 {
+			continue
 		}
-
+		headers[textproto.CanonicalMIMEHeaderKey(strings.TrimSpace(line[:idx]))] = strings.TrimSpace(line[idx+1:])
 	}
 
-	return headers, nil
+	return code, headers, nil
 }
@@ -0,0 +1,94 @@
+// Copyright 2019 Setin Sergei
+// Licensed under the Apache License, Version 2.0 (the "License")
+
+package iceclient
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+// metaReader - strips ICY metadata blocks, which are inserted into the stream every metaInt bytes,
+// and passes stream titles from them to onMeta
+type metaReader struct {
+	reader  *bufio.Reader
+	metaInt int
+	// audio bytes left before the next metadata block
+	left   int
+	onMeta func(title string)
+}
+
+func newMetaReader(reader *bufio.Reader, metaInt int, onMeta func(title string)) *metaReader {
+	return &metaReader{reader: reader, metaInt: metaInt, left: metaInt, onMeta: onMeta}
+}
+
+// Read - reads audio data across metadata blocks. Metadata block is read as soon as it's due, since it follows
+// audio data in the stream, further audio is returned only if it's already received
+func (m *metaReader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if m.left == 0 {
+			if err := m.readMeta(); err != nil {
+				return n, err
+			}
+			m.left = m.metaInt
+			continue
+		}
+		if n > 0 && m.reader.Buffered() == 0 {
+			break
+		}
+		chunk := p[n:]
+		if len(chunk) > m.left {
+			chunk = chunk[:m.left]
+		}
+		read, err := m.reader.Read(chunk)
+		n += read
+		m.left -= read
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// readMeta - reads metadata block: length byte (in 16 bytes units) and text like StreamTitle='...';
+func (m *metaReader) readMeta() error {
+	var size [1]byte
+	if _, err := io.ReadFull(m.reader, size[:]); err != nil {
+		return err
+	}
+	if size[0] == 0 {
+		// metadata is not changed
+		return nil
+	}
+	block := make([]byte, int(size[0])*16)
+	if _, err := io.ReadFull(m.reader, block); err != nil {
+		return err
+	}
+	if m.onMeta != nil {
+		if title, ok := streamTitle(strings.TrimRight(string(block), "\x00")); ok {
+			m.onMeta(title)
+		}
+	}
+	return nil
+}
+
+// streamTitle - extracts StreamTitle value from metadata text
+func streamTitle(meta string) (string, bool) {
+	const prefix = "StreamTitle='"
+	start := strings.Index(meta, prefix)
+	if start == -1 {
+		return "", false
+	}
+	meta = meta[start+len(prefix):]
+	// title could contain quotes, so it ends with the quote followed by semicolon
+	end := strings.Index(meta, "';")
+	if end == -1 {
+		end = strings.LastIndex(meta, "'")
+	}
+	if end == -1 {
+		return meta, true
+	}
+	return meta[:end], true
+}
@@ -0,0 +1,287 @@
+// Copyright 2019 Setin Sergei
+// Licensed under the Apache License, Version 2.0 (the "License")
+
+package ice
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// accessOptions - CIDR allow and deny lists, used globally and per mount
+type accessOptions struct {
+	ListenerAllow []string `yaml:"ListenerAllow"`
+	ListenerDeny  []string `yaml:"ListenerDeny"`
+	SourceAllow   []string `yaml:"SourceAllow"`
+	SourceDeny    []string `yaml:"SourceDeny"`
+}
+
+// ipList - list of networks, single addresses are stored as /32 or /128 networks
+type ipList []*net.IPNet
+
+// parseIPNet - parses CIDR or single ip address
+func parseIPNet(s string) (*net.IPNet, error) {
+	s = strings.TrimSpace(s)
+	if strings.Contains(s, "/") {
+		_, network, err := net.ParseCIDR(s)
+		return network, err
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, fmt.Errorf("%s is not a valid ip address or network", s)
+	}
+	bits := 128
+	if ip4 := ip.To4(); ip4 != nil {
+		ip, bits = ip4, 32
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+}
+
+func parseIPList(entries []string) (ipList, error) {
+	result := make(ipList, 0, len(entries))
+	for _, entry := range entries {
+		network, err := parseIPNet(entry)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, network)
+	}
+	return result, nil
+}
+
+func (l ipList) contains(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, network := range l {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// accessList - allow and deny lists of listeners or sources
+type accessList struct {
+	allow ipList
+	deny  ipList
+}
+
+// permits - deny list has priority, non-empty allow list admits only its addresses
+func (a accessList) permits(ip net.IP) bool {
+	if a.deny.contains(ip) {
+		return false
+	}
+	return len(a.allow) == 0 || a.allow.contains(ip)
+}
+
+// accessRules - parsed accessOptions
+type accessRules struct {
+	listeners accessList
+	sources   accessList
+}
+
+func newAccessRules(o accessOptions) (accessRules, error) {
+	var rules accessRules
+	var err error
+	if rules.listeners.allow, err = parseIPList(o.ListenerAllow); err != nil {
+		return rules, err
+	}
+	if rules.listeners.deny, err = parseIPList(o.ListenerDeny); err != nil {
+		return rules, err
+	}
+	if rules.sources.allow, err = parseIPList(o.SourceAllow); err != nil {
+		return rules, err
+	}
+	if rules.sources.deny, err = parseIPList(o.SourceDeny); err != nil {
+		return rules, err
+	}
+	return rules, nil
+}
+
+func (r accessRules) permits(ip net.IP, isSource bool) bool {
+	if isSource {
+		return r.sources.permits(ip)
+	}
+	return r.listeners.permits(ip)
+}
+
+// banList - addresses banned in runtime by admin, persisted to file if it is set
+type banList struct {
+	mux      sync.Mutex
+	fileName string
+	entries  map[string]*net.IPNet
+}
+
+// newBanList - creates ban list and loads it from fileName, missing file means empty list
+func newBanList(fileName string) (*banList, error) {
+	b := &banList{fileName: fileName, entries: make(map[string]*net.IPNet)}
+	if fileName == "" {
+		return b, nil
+	}
+	file, err := os.Open(fileName)
+	if os.IsNotExist(err) {
+		return b, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		network, err := parseIPNet(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", fileName, err.Error())
+		}
+		b.entries[network.String()] = network
+	}
+	return b, scanner.Err()
+}
+
+// save - writes the list to file, caller must hold the lock
+func (b *banList) save() error {
+	if b.fileName == "" {
+		return nil
+	}
+	var sb strings.Builder
+	for _, entry := range b.sorted() {
+		sb.WriteString(entry)
+		sb.WriteString("\n")
+	}
+	return ioutil.WriteFile(b.fileName, []byte(sb.String()), 0644)
+}
+
+func (b *banList) sorted() []string {
+	result := make([]string, 0, len(b.entries))
+	for entry := range b.entries {
+		result = append(result, entry)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// add - bans address or network, returns it in canonical form.
+// Returned error with non-nil network means that ban is active, but list was not saved
+func (b *banList) add(s string) (*net.IPNet, error) {
+	network, err := parseIPNet(s)
+	if err != nil {
+		return nil, err
+	}
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	b.entries[network.String()] = network
+	return network, b.save()
+}
+
+// remove - unbans address or network, returns it in canonical form or nil if it is not banned
+func (b *banList) remove(s string) (*net.IPNet, error) {
+	network, err := parseIPNet(s)
+	if err != nil {
+		return nil, err
+	}
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	if _, ok := b.entries[network.String()]; !ok {
+		return nil, errors.New(network.String() + " is not banned")
+	}
+	delete(b.entries, network.String())
+	return network, b.save()
+}
+
+func (b *banList) contains(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	for _, network := range b.entries {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *banList) list() []string {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	return b.sorted()
+}
+
+// checkAccess - checks if client could connect to the mount as a listener or source
+func (i *Server) checkAccess(m *mount, host string, isSource bool) error {
+	ip := net.ParseIP(host)
+	if i.bans.contains(ip) {
+		return errors.New("address is banned")
+	}
+	i.mux.Lock()
+	global := i.access
+	i.mux.Unlock()
+	if !global.permits(ip, isSource) {
+		return errors.New("address is not allowed by server")
+	}
+	m.mux.Lock()
+	local := m.access
+	m.mux.Unlock()
+	if !local.permits(ip, isSource) {
+		return errors.New("address is not allowed by mount")
+	}
+	return nil
+}
+
+// acquireConnection - counts connection of the address, returns false if ConnectionsPerIP limit is reached
+func (i *Server) acquireConnection(host string) bool {
+	i.mux.Lock()
+	defer i.mux.Unlock()
+	limit := i.Options.Limits.ConnectionsPerIP
+	if limit > 0 && i.connections[host] >= limit {
+		return false
+	}
+	if i.connections == nil {
+		i.connections = make(map[string]int)
+	}
+	i.connections[host]++
+	return true
+}
+
+func (i *Server) releaseConnection(host string) {
+	i.mux.Lock()
+	defer i.mux.Unlock()
+	if i.connections[host] <= 1 {
+		delete(i.connections, host)
+	} else {
+		i.connections[host]--
+	}
+}
+
+// dropBanned - disconnects listeners and sources from the banned network, returns their number
+func (i *Server) dropBanned(network *net.IPNet) int {
+	dropped := 0
+	for _, m := range i.Mounts() {
+		for _, l := range m.getListeners() {
+			if network.Contains(net.ParseIP(l.Addr)) {
+				l.kill()
+				dropped++
+			}
+		}
+		m.mux.Lock()
+		if m.sourceConn != nil && network.Contains(net.ParseIP(i.getHost(m.sourceConn.RemoteAddr().String()))) {
+			_ = m.sourceConn.Close()
+			dropped++
+		}
+		m.mux.Unlock()
+	}
+	return dropped
+}
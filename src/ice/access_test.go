@@ -0,0 +1,56 @@
+// Copyright 2019 Setin Sergei
+// Licensed under the Apache License, Version 2.0 (the "License")
+
+package ice
+
+import (
+	"net"
+	"testing"
+)
+
+func TestAccessRules(t *testing.T) {
+	rules, err := newAccessRules(accessOptions{
+		ListenerAllow: []string{"10.0.0.0/8", "2001:db8::/32"},
+		ListenerDeny:  []string{"10.1.0.0/16"},
+		SourceDeny:    []string{"192.0.2.1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		ip       string
+		isSource bool
+		want     bool
+	}{
+		{"10.0.0.1", false, true},
+		{"10.1.2.3", false, false},
+		{"192.0.2.1", false, false},
+		{"2001:db8::1", false, true},
+		{"2001:db9::1", false, false},
+		{"192.0.2.1", true, false},
+		{"192.0.2.2", true, true},
+	}
+	for _, tt := range tests {
+		if got := rules.permits(net.ParseIP(tt.ip), tt.isSource); got != tt.want {
+			t.Errorf("permits(%s, source %v) = %v, want %v", tt.ip, tt.isSource, got, tt.want)
+		}
+	}
+
+	if _, err := newAccessRules(accessOptions{SourceAllow: []string{"10.0.0.0/33"}}); err == nil {
+		t.Error("wrong network accepted")
+	}
+}
+
+func TestGetHost(t *testing.T) {
+	srv := &Server{}
+	for addr, want := range map[string]string{
+		"192.0.2.1:5000":     "192.0.2.1",
+		"[2001:db8::1]:5000": "2001:db8::1",
+		"192.0.2.1":          "192.0.2.1",
+	} {
+		if got := srv.getHost(addr); got != want {
+			t.Errorf("getHost(%s) = %s, want %s", addr, got, want)
+		}
+	}
+}
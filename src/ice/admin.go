@@ -0,0 +1,356 @@
+// Copyright 2019 Setin Sergei
+// Licensed under the Apache License, Version 2.0 (the "License")
+
+package ice
+
+import (
+	"encoding/xml"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	cAdminRealm = "Icecast2 Server"
+	// time format of *_iso8601 fields in icecast stats
+	cISO8601 = "2006-01-02T15:04:05-0700"
+)
+
+// iceResponse - icecast style answer of admin commands
+type iceResponse struct {
+	XMLName xml.Name `xml:"iceresponse"`
+	Message string   `xml:"message"`
+	Return  int      `xml:"return"`
+}
+
+// adminStats - answer of /admin/stats
+type adminStats struct {
+	XMLName            xml.Name      `xml:"icestats"`
+	Admin              string        `xml:"admin"`
+	Clients            int32         `xml:"clients"`
+	Host               string        `xml:"host"`
+	Listeners          int32         `xml:"listeners"`
+	Location           string        `xml:"location"`
+	ServerID           string        `xml:"server_id"`
+	ServerStart        string        `xml:"server_start"`
+	ServerStartISO8601 string        `xml:"server_start_iso8601"`
+	Sources            int32         `xml:"sources"`
+	Source             []sourceStats `xml:"source"`
+}
+
+type sourceStats struct {
+	Mount              string `xml:"mount,attr" json:"-"`
+	AudioInfo          string `xml:"audio_info" json:"audio_info"`
+	AudioCodec         string `xml:"audio_codec,omitempty" json:"audio_codec,omitempty"`
+	Bitrate            int    `xml:"bitrate" json:"bitrate"`
+	DetectedBitrate    int    `xml:"detected_bitrate,omitempty" json:"detected_bitrate,omitempty"`
+	Channels           int    `xml:"channels,omitempty" json:"channels,omitempty"`
+	SampleRate         int    `xml:"samplerate,omitempty" json:"samplerate,omitempty"`
+	Genre              string `xml:"genre" json:"genre"`
+	ListenerPeak       int32  `xml:"listener_peak" json:"listener_peak"`
+	Listeners          int32  `xml:"listeners" json:"listeners"`
+	ListenURL          string `xml:"listenurl" json:"listenurl"`
+	MaxListeners       string `xml:"max_listeners" json:"-"`
+	ServerDescription  string `xml:"server_description" json:"server_description"`
+	ServerName         string `xml:"server_name" json:"server_name"`
+	ServerType         string `xml:"server_type" json:"server_type"`
+	StreamStart        string `xml:"stream_start" json:"stream_start"`
+	StreamStartISO8601 string `xml:"stream_start_iso8601" json:"stream_start_iso8601"`
+	Title              string `xml:"title" json:"title"`
+	TotalBytesRead     int64  `xml:"total_bytes_read" json:"-"`
+	TotalBytesSent     int64  `xml:"total_bytes_sent" json:"-"`
+}
+
+// adminMounts - answer of /admin/listmounts
+type adminMounts struct {
+	XMLName xml.Name      `xml:"icestats"`
+	Source  []mountStatus `xml:"source"`
+}
+
+type mountStatus struct {
+	Mount       string `xml:"mount,attr"`
+	Fallback    string `xml:"fallback"`
+	Listeners   int32  `xml:"listeners"`
+	Connected   int64  `xml:"Connected"`
+	ContentType string `xml:"content-type"`
+}
+
+// adminClients - answer of /admin/listclients
+type adminClients struct {
+	XMLName xml.Name      `xml:"icestats"`
+	Source  clientsSource `xml:"source"`
+}
+
+type clientsSource struct {
+	Mount     string         `xml:"mount,attr"`
+	Listeners int            `xml:"Listeners"`
+	Listener  []clientStatus `xml:"listener"`
+}
+
+type clientStatus struct {
+	IP        string `xml:"IP"`
+	UserAgent string `xml:"UserAgent"`
+	Connected int64  `xml:"Connected"`
+	ID        uint64 `xml:"ID"`
+}
+
+// adminBans - answer of /admin/bans
+type adminBans struct {
+	XMLName xml.Name `xml:"icestats"`
+	Ban     []string `xml:"ban"`
+}
+
+// adminAuth - checks admin credentials, returns false and answers 401 if they are wrong
+func (i *Server) adminAuth(w http.ResponseWriter, r *http.Request) bool {
+	i.mux.Lock()
+	adminUser, adminPassword := i.Options.Auth.AdminUser, i.Options.Auth.AdminPassword
+	i.mux.Unlock()
+	user, password, ok := r.BasicAuth()
+	if ok && adminPassword > "" && equalStrings(user, adminUser) && checkPassword(adminPassword, password) {
+		return true
+	}
+	w.Header().Set("WWW-Authenticate", "Basic realm=\""+cAdminRealm+"\"")
+	http.Error(w, "Not authorized", http.StatusUnauthorized)
+	return false
+}
+
+func (i *Server) writeXML(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+	_, _ = w.Write([]byte(xml.Header))
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		i.logger.Error(err.Error())
+	}
+}
+
+func (i *Server) writeIceResponse(w http.ResponseWriter, message string, ok bool) {
+	resp := iceResponse{Message: message}
+	if ok {
+		resp.Return = 1
+	}
+	i.writeXML(w, resp)
+}
+
+func (i *Server) reloadHandler(w http.ResponseWriter, r *http.Request) {
+	if !i.adminAuth(w, r) {
+		return
+	}
+	if err := i.Reload(); err != nil {
+		i.writeIceResponse(w, "Config reload failed: "+err.Error(), false)
+		return
+	}
+	i.writeIceResponse(w, "Config reloaded", true)
+}
+
+// adminMount - returns mount requested by admin command, answers with error if there is no such mount
+func (i *Server) adminMount(w http.ResponseWriter, r *http.Request, param string) *mount {
+	name := r.URL.Query().Get(param)
+	m := i.getMount(name)
+	if m == nil {
+		i.writeIceResponse(w, "Source "+name+" does not exist", false)
+	}
+	return m
+}
+
+func (i *Server) statsHandler(w http.ResponseWriter, r *http.Request) {
+	if !i.adminAuth(w, r) {
+		return
+	}
+	i.mux.Lock()
+	stats := adminStats{
+		Admin:              i.Options.Admin,
+		Host:               i.Options.Host,
+		Location:           i.Options.Location,
+		ServerID:           i.serverName + " " + i.version,
+		ServerStart:        i.StartedTime.Format(time.RFC1123Z),
+		ServerStartISO8601: i.StartedTime.Format(cISO8601),
+	}
+	i.mux.Unlock()
+	stats.Listeners = atomic.LoadInt32(&i.ListenersCount)
+	stats.Sources = atomic.LoadInt32(&i.SourcesCount)
+	stats.Clients = stats.Listeners + stats.Sources
+
+	for _, m := range i.Mounts() {
+		if !m.isStarted() {
+			continue
+		}
+		stats.Source = append(stats.Source, m.getSourceStats())
+	}
+	i.writeXML(w, stats)
+}
+
+func (i *Server) listMountsHandler(w http.ResponseWriter, r *http.Request) {
+	if !i.adminAuth(w, r) {
+		return
+	}
+	result := adminMounts{}
+	for _, m := range i.Mounts() {
+		if !m.isStarted() {
+			continue
+		}
+		m.mux.Lock()
+		result.Source = append(result.Source, mountStatus{
+			Mount:       "/" + m.Name,
+			Fallback:    m.FallbackMount,
+			Listeners:   atomic.LoadInt32(&m.State.Listeners),
+			Connected:   int64(time.Since(m.State.StartedTime).Seconds()),
+			ContentType: m.ContentType,
+		})
+		m.mux.Unlock()
+	}
+	i.writeXML(w, result)
+}
+
+// streamListHandler - answers with paths of mounts with connected sources one per line, it's polled by slaves
+func (i *Server) streamListHandler(w http.ResponseWriter, r *http.Request) {
+	if !i.adminAuth(w, r) {
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	for _, m := range i.Mounts() {
+		if m.isStarted() && !m.isRetired() {
+			_, _ = w.Write([]byte("/" + m.Name + "\n"))
+		}
+	}
+}
+
+func (i *Server) listClientsHandler(w http.ResponseWriter, r *http.Request) {
+	if !i.adminAuth(w, r) {
+		return
+	}
+	m := i.adminMount(w, r, "mount")
+	if m == nil {
+		return
+	}
+	listeners := m.getListeners()
+	result := adminClients{Source: clientsSource{Mount: "/" + m.Name, Listeners: len(listeners)}}
+	for _, l := range listeners {
+		result.Source.Listener = append(result.Source.Listener, clientStatus{
+			IP:        l.Addr,
+			UserAgent: l.UserAgent,
+			Connected: int64(time.Since(l.Started).Seconds()),
+			ID:        l.ID,
+		})
+	}
+	i.writeXML(w, result)
+}
+
+func (i *Server) killClientHandler(w http.ResponseWriter, r *http.Request) {
+	if !i.adminAuth(w, r) {
+		return
+	}
+	m := i.adminMount(w, r, "mount")
+	if m == nil {
+		return
+	}
+	idStr := r.URL.Query().Get("id")
+	id, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		i.writeIceResponse(w, "Client "+idStr+" not found", false)
+		return
+	}
+	l := m.getListener(id)
+	if l == nil {
+		i.writeIceResponse(w, "Client "+idStr+" not found", false)
+		return
+	}
+	l.kill()
+	i.logger.Info("Admin removed client %d from %s", id, m.Name)
+	i.writeIceResponse(w, "Client "+idStr+" removed", true)
+}
+
+func (i *Server) killSourceHandler(w http.ResponseWriter, r *http.Request) {
+	if !i.adminAuth(w, r) {
+		return
+	}
+	m := i.adminMount(w, r, "mount")
+	if m == nil {
+		return
+	}
+	if !m.killSource() {
+		i.writeIceResponse(w, "Source /"+m.Name+" is not connected", false)
+		return
+	}
+	i.logger.Info("Admin removed source of %s", m.Name)
+	i.writeIceResponse(w, "Source Removed", true)
+}
+
+func (i *Server) moveClientsHandler(w http.ResponseWriter, r *http.Request) {
+	if !i.adminAuth(w, r) {
+		return
+	}
+	m := i.adminMount(w, r, "mount")
+	if m == nil {
+		return
+	}
+	dst := i.adminMount(w, r, "destination")
+	if dst == nil {
+		return
+	}
+	if dst == m {
+		i.writeIceResponse(w, "Destination must be different from source", false)
+		return
+	}
+	if dst.source() == nil {
+		i.writeIceResponse(w, "Destination /"+dst.Name+" is not running", false)
+		return
+	}
+	if !m.compatible(dst) {
+		i.writeIceResponse(w, "Destination /"+dst.Name+" has different content type", false)
+		return
+	}
+	m.moveListeners(dst)
+	i.logger.Info("Admin moved clients from %s to %s", m.Name, dst.Name)
+	i.writeIceResponse(w, "Clients moved from /"+m.Name+" to /"+dst.Name, true)
+}
+
+func (i *Server) bansHandler(w http.ResponseWriter, r *http.Request) {
+	if !i.adminAuth(w, r) {
+		return
+	}
+	i.writeXML(w, adminBans{Ban: i.bans.list()})
+}
+
+func (i *Server) banHandler(w http.ResponseWriter, r *http.Request) {
+	if !i.adminAuth(w, r) {
+		return
+	}
+	network, err := i.bans.add(r.URL.Query().Get("ip"))
+	if network == nil {
+		i.writeIceResponse(w, err.Error(), false)
+		return
+	}
+	if err != nil {
+		i.logger.Error("Ban list: %s", err.Error())
+	}
+	dropped := i.dropBanned(network)
+	i.logger.Info("Admin banned %s, %d clients dropped", network.String(), dropped)
+	i.writeIceResponse(w, network.String()+" banned, "+strconv.Itoa(dropped)+" clients dropped", true)
+}
+
+func (i *Server) unbanHandler(w http.ResponseWriter, r *http.Request) {
+	if !i.adminAuth(w, r) {
+		return
+	}
+	network, err := i.bans.remove(r.URL.Query().Get("ip"))
+	if network == nil {
+		i.writeIceResponse(w, err.Error(), false)
+		return
+	}
+	if err != nil {
+		i.logger.Error("Ban list: %s", err.Error())
+	}
+	i.logger.Info("Admin unbanned %s", network.String())
+	i.writeIceResponse(w, network.String()+" unbanned", true)
+}
+
+func (i *Server) metaHandler(w http.ResponseWriter, r *http.Request) {
+	m := i.getMount(r.URL.Query().Get("mount"))
+	if m == nil {
+		http.Error(w, "Source does not exist", http.StatusBadRequest)
+		return
+	}
+	m.meta(w, r)
+}
@@ -0,0 +1,237 @@
+// Copyright 2019 Setin Sergei
+// Licensed under the Apache License, Version 2.0 (the "License")
+
+package ice
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// equalStrings - compares strings in constant time
+func equalStrings(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// checkPassword - checks password against the stored one. Stored password could be
+// a bcrypt ($2a$, $2b$, $2y$) or argon2 ($argon2id$, $argon2i$) hash, otherwise it is plain text
+func checkPassword(stored, password string) bool {
+	switch {
+	case bcryptHash(stored):
+		return bcrypt.CompareHashAndPassword([]byte(stored), []byte(password)) == nil
+	case strings.HasPrefix(stored, "$argon2"):
+		return checkArgon2(stored, password)
+	}
+	return equalStrings(stored, password)
+}
+
+func bcryptHash(stored string) bool {
+	return strings.HasPrefix(stored, "$2a$") || strings.HasPrefix(stored, "$2b$") || strings.HasPrefix(stored, "$2y$")
+}
+
+// hashedPassword - returns true if stored password is bcrypt or argon2 hash
+func hashedPassword(stored string) bool {
+	return bcryptHash(stored) || strings.HasPrefix(stored, "$argon2")
+}
+
+// checkArgon2 - checks password against argon2 hash in PHC format:
+// $argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>
+func checkArgon2(stored, password string) bool {
+	parts := strings.Split(stored, "$")
+	if len(parts) != 6 {
+		return false
+	}
+
+	var version int
+	var memory, time uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &threads); err != nil {
+		return false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false
+	}
+	hash, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false
+	}
+
+	var computed []byte
+	switch parts[1] {
+	case "argon2id":
+		computed = argon2.IDKey([]byte(password), salt, time, memory, threads, uint32(len(hash)))
+	case "argon2i":
+		computed = argon2.Key([]byte(password), salt, time, memory, threads, uint32(len(hash)))
+	default:
+		return false
+	}
+	return subtle.ConstantTimeCompare(hash, computed) == 1
+}
+
+// checkCredentialsFile - checks user and password against htpasswd-like file with user:password lines,
+// passwords could be hashed the same way as in config
+func checkCredentialsFile(fileName, user, password string) (bool, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		pair := strings.SplitN(line, ":", 2)
+		if len(pair) != 2 || !equalStrings(pair[0], user) {
+			continue
+		}
+		return checkPassword(pair[1], password), nil
+	}
+	return false, scanner.Err()
+}
+
+// checkCredentials - checks source credentials against mount's User and Password and its CredentialsFile
+func (m *mount) checkCredentials(user, password string) bool {
+	m.mux.Lock()
+	mountUser, mountPassword, fileName := m.User, m.Password, m.CredentialsFile
+	m.mux.Unlock()
+
+	if mountUser > "" && equalStrings(mountUser, user) && checkPassword(mountPassword, password) {
+		return true
+	}
+	if fileName == "" {
+		return false
+	}
+	ok, err := checkCredentialsFile(fileName, user, password)
+	if err != nil {
+		m.logger.Error("Credentials file of %s: %s", m.Name, err.Error())
+	}
+	return ok
+}
+
+// checkShoutcastPassword - checks password of shoutcast source, which has no user name, against mount's credentials.
+// Source is checked as mount's User, or as "source" user of CredentialsFile (like in icecast), if mount has no User
+func (m *mount) checkShoutcastPassword(password string) bool {
+	m.mux.Lock()
+	user := m.User
+	m.mux.Unlock()
+	if user == "" {
+		user = cShoutcastUser
+	}
+	return m.checkCredentials(user, password)
+}
+
+// checkPlainPassword - checks password against mount's Password, if it's stored as plain text
+func (m *mount) checkPlainPassword(password string) bool {
+	m.mux.Lock()
+	stored := m.Password
+	m.mux.Unlock()
+	return stored > "" && !hashedPassword(stored) && equalStrings(stored, password)
+}
+
+// authRequest - posts client's data to url auth backend, returns response headers
+func (m *mount) authRequest(authURL string, action string, ip string, r *http.Request, extra url.Values) (http.Header, error) {
+	values := url.Values{}
+	values.Set("action", action)
+	values.Set("server", m.server.Options.Host)
+	values.Set("port", strconv.Itoa(m.server.Options.Socket.Port))
+	values.Set("mount", "/"+m.Name)
+	values.Set("ip", ip)
+	values.Set("agent", r.UserAgent())
+	values.Set("referer", r.Referer())
+	values.Set("token", r.URL.Query().Get("token"))
+	if user, password, ok := r.BasicAuth(); ok {
+		values.Set("user", user)
+		values.Set("pass", password)
+	}
+	for k, v := range extra {
+		values[k] = v
+	}
+
+	resp, err := m.server.authClient.PostForm(authURL, values)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("auth backend answered " + resp.Status)
+	}
+	return resp.Header, nil
+}
+
+// authResult - client is admitted only if backend answers with "icecast-auth-user: 1" header,
+// otherwise returns error with the reason
+func authResult(header http.Header) error {
+	if header.Get("icecast-auth-user") != "1" {
+		if message := header.Get("icecast-auth-message"); message > "" {
+			return errors.New(message)
+		}
+		return errors.New("rejected by auth backend")
+	}
+	return nil
+}
+
+// listenerAdd - asks ListenerAddURL whether listener could be admitted, returns error with the reason if not
+func (m *mount) listenerAdd(l *listener, r *http.Request) error {
+	m.mux.Lock()
+	addURL := m.ListenerAddURL
+	m.mux.Unlock()
+	if addURL == "" {
+		return nil
+	}
+
+	client := url.Values{}
+	client.Set("client", strconv.FormatUint(l.ID, 10))
+	header, err := m.authRequest(addURL, "listener_add", l.Addr, r, client)
+	if err != nil {
+		return err
+	}
+	return authResult(header)
+}
+
+// sourceAuth - asks SourceAuthURL whether source could be admitted, returns error with the reason if not
+func (m *mount) sourceAuth(authURL string, r *http.Request) error {
+	header, err := m.authRequest(authURL, "stream_auth", m.server.getHost(r.RemoteAddr), r, nil)
+	if err != nil {
+		return err
+	}
+	return authResult(header)
+}
+
+// listenerRemove - notifies ListenerRemoveURL that listener has disconnected
+func (m *mount) listenerRemove(l *listener, r *http.Request) {
+	m.mux.Lock()
+	removeURL := m.ListenerRemoveURL
+	m.mux.Unlock()
+	if removeURL == "" {
+		return
+	}
+
+	client := url.Values{}
+	client.Set("client", strconv.FormatUint(l.ID, 10))
+	client.Set("duration", strconv.Itoa(int(time.Since(l.Started).Seconds())))
+	if _, err := m.authRequest(removeURL, "listener_remove", l.Addr, r, client); err != nil {
+		m.logger.Error("Listener %d of %s: listener_remove: %s", l.ID, m.Name, err.Error())
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright 2019 Setin Sergei
+// Licensed under the Apache License, Version 2.0 (the "License")
+
+package ice
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type nopLogger struct{}
+
+func (nopLogger) Error(format string, v ...interface{})   {}
+func (nopLogger) Debug(format string, v ...interface{})   {}
+func (nopLogger) Info(format string, v ...interface{})    {}
+func (nopLogger) Warning(format string, v ...interface{}) {}
+func (nopLogger) Access(format string, v ...interface{})  {}
+func (nopLogger) Stat(format string, v ...interface{})    {}
+func (nopLogger) Log(format string, v ...interface{})     {}
+func (nopLogger) Close()                                  {}
+
+func TestCheckPassword(t *testing.T) {
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	salt := []byte("0123456789abcdef")
+	argonHash := "$argon2id$v=19$m=1024,t=1,p=1$" + base64.RawStdEncoding.EncodeToString(salt) + "$" +
+		base64.RawStdEncoding.EncodeToString(argon2.IDKey([]byte("secret"), salt, 1, 1024, 1, 32))
+
+	tests := []struct {
+		stored   string
+		password string
+		want     bool
+	}{
+		{"secret", "secret", true},
+		{"secret", "wrong", false},
+		{"secret", "", false},
+		{string(bcryptHash), "secret", true},
+		{string(bcryptHash), "wrong", false},
+		{argonHash, "secret", true},
+		{argonHash, "wrong", false},
+		{"$argon2id$broken", "secret", false},
+	}
+	for _, tt := range tests {
+		if got := checkPassword(tt.stored, tt.password); got != tt.want {
+			t.Errorf("checkPassword(%q, %q) = %v, want %v", tt.stored, tt.password, got, tt.want)
+		}
+	}
+}
+
+func TestListenerURLAuth(t *testing.T) {
+	var mux sync.Mutex
+	var requests []url.Values
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Error(err)
+		}
+		mux.Lock()
+		requests = append(requests, r.PostForm)
+		mux.Unlock()
+		if r.PostForm.Get("action") == "listener_add" {
+			if r.PostForm.Get("token") == "good" {
+				w.Header().Set("icecast-auth-user", "1")
+			} else {
+				w.Header().Set("icecast-auth-message", "subscription expired")
+			}
+		}
+	}))
+	defer backend.Close()
+
+	srv := &Server{authClient: backend.Client()}
+	m := &mount{
+		Name:              "RockRadio96",
+		ListenerAddURL:    backend.URL + "/add",
+		ListenerRemoveURL: backend.URL + "/remove",
+		server:            srv,
+		logger:            nopLogger{},
+	}
+
+	r := httptest.NewRequest("GET", "/RockRadio96?token=good", nil)
+	r.RemoteAddr = "10.0.0.1:5000"
+	r.Header.Set("User-Agent", "TestPlayer")
+	l := m.newListener(r)
+	if err := m.listenerAdd(l, r); err != nil {
+		t.Fatalf("listener with good token rejected: %s", err.Error())
+	}
+	m.listenerRemove(l, r)
+
+	bad := httptest.NewRequest("GET", "/RockRadio96?token=bad", nil)
+	err := m.listenerAdd(m.newListener(bad), bad)
+	if err == nil || err.Error() != "subscription expired" {
+		t.Fatalf("listener with bad token: got %v, want subscription expired", err)
+	}
+
+	if len(requests) != 3 {
+		t.Fatalf("backend got %d requests, want 3", len(requests))
+	}
+	add, remove := requests[0], requests[1]
+	for k, want := range map[string]string{"action": "listener_add", "mount": "/RockRadio96", "ip": "10.0.0.1",
+		"agent": "TestPlayer", "token": "good", "client": "1"} {
+		if got := add.Get(k); got != want {
+			t.Errorf("listener_add %s = %q, want %q", k, got, want)
+		}
+	}
+	if remove.Get("action") != "listener_remove" || remove.Get("client") != "1" || remove.Get("duration") != "0" {
+		t.Errorf("unexpected listener_remove request %v", remove)
+	}
+}
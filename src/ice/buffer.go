@@ -6,6 +6,7 @@ package ice
 import (
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 //BufElement - kind of buffer page
@@ -16,6 +17,11 @@ type bufElement struct {
 	next   *bufElement
 	prev   *bufElement
 	mux    sync.Mutex
+	// position of the page in the stream, offset of the first frame starting in the page (-1 if there is no one)
+	// and duration of frames starting in the page. Frames are marked, when they are parsed completely
+	start       int64
+	frameOffset int
+	duration    time.Duration
 }
 
 // BufferQueue - queue, which stores stream fragments from SOURCE
@@ -26,6 +32,9 @@ type bufferQueue struct {
 	minBufferSize int
 	first, last   *bufElement
 	pool          *sync.Pool
+	// bytes appended since the start and whether the stream is split into frames
+	total  int64
+	framed bool
 }
 
 // BufferInfo - struct for monitoring
@@ -103,6 +112,10 @@ func (q *bufferQueue) newBufElement(buffer []byte, readed int) *bufElement {
 	}
 
 	t.buffer = q.pool.Get().([]byte)
+	if cap(t.buffer) < readed {
+		// source sends more, than its configured bitrate
+		t.buffer = make([]byte, readed)
+	}
 	t.buffer = t.buffer[:readed]
 	t.len = readed
 	copy(t.buffer, buffer)
@@ -162,8 +175,9 @@ func (q *bufferQueue) Last() *bufElement {
 	return q.last
 }
 
-// Start - returns the element to start with
-func (q *bufferQueue) Start(burstSize int) *bufElement {
+// Start - returns the element to start with and number of bytes from it to the end. Burst is burstDuration
+// of audio, if durations of pages are known, otherwise it's burstSize bytes
+func (q *bufferQueue) Start(burstSize int, burstDuration time.Duration) (*bufElement, int) {
 	q.mux.Lock()
 	defer q.mux.Unlock()
 
@@ -171,17 +185,77 @@ func (q *bufferQueue) Start(burstSize int) *bufElement {
 	var t *bufElement
 	t = q.last
 	if t == nil {
-		return nil
+		return nil, 0
 	}
 
-	for {
-		if t.prev == nil || burst > burstSize {
-			break
+	if q.framed && burstDuration > 0 {
+		// durations of pages are known for framed streams only
+		for duration := t.duration; duration < burstDuration && t.prev != nil; duration += t.duration {
+			t = t.prev
+		}
+	} else {
+		for ; burstSize > 0 && burst <= burstSize && t.prev != nil; t = t.prev {
+			burst += t.len
+		}
+	}
+	// page has to contain the beginning of a frame
+	for q.framed && t.frameOffset < 0 && t.next != nil {
+		t = t.next
+	}
+
+	burst = 0
+	for p := t; p != nil; p = p.next {
+		burst += p.len
+	}
+	return t, burst
+}
+
+// FrameOffset - returns offset of the first frame in the page, listeners have to start sending from it
+func (q *bufferQueue) FrameOffset(t *bufElement) int {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	if t.frameOffset < 0 {
+		return 0
+	}
+	return t.frameOffset
+}
+
+// SetFramed - turns on marking of frames in pages, which are appended since position from of the stream
+func (q *bufferQueue) SetFramed(framed bool, from int64) {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	q.framed = framed
+	offset := 0
+	if framed {
+		offset = -1
+	}
+	for t := q.last; t != nil && t.start >= from; t = t.prev {
+		t.frameOffset = offset
+		t.duration = 0
+	}
+}
+
+// Total - returns number of bytes appended since the start
+func (q *bufferQueue) Total() int64 {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	return q.total
+}
+
+// MarkFrame - records frame, which starts at position pos of the stream, in the page containing it
+func (q *bufferQueue) MarkFrame(pos int64, duration time.Duration) {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	// frame is parsed when the next one's header is received, so it's usually in one of the last pages
+	for t := q.last; t != nil && t.start+int64(t.len) > pos; t = t.prev {
+		if t.start <= pos {
+			if t.frameOffset < 0 {
+				t.frameOffset = int(pos - t.start)
+			}
+			t.duration += duration
+			return
 		}
-		burst += t.len
-		t = t.prev
 	}
-	return t
 }
 
 // checkAndTruncate - check if the max buffer size is reached and try to truncate it
@@ -228,6 +302,12 @@ func (q *bufferQueue) Append(buffer []byte, read int) {
 	q.mux.Lock()
 	defer q.mux.Unlock()
 
+	t.start = q.total
+	q.total += int64(read)
+	if q.framed {
+		t.frameOffset = -1
+	}
+
 	if q.size == 0 {
 		q.size = 1
 		t.next = nil
@@ -0,0 +1,55 @@
+// Copyright 2019 Setin Sergei
+// Licensed under the Apache License, Version 2.0 (the "License")
+
+package ice
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBufferFrameStart(t *testing.T) {
+	var q bufferQueue
+	q.Init(4, &sync.Pool{New: func() interface{} { return make([]byte, 1000) }})
+	q.SetFramed(true, 0)
+
+	var s frameSplitter
+	s.reset(parseMP3Frame)
+	// pages of 1000 bytes don't match 417 bytes frames
+	data := append([]byte("garbage"), testMP3Frames(12)...)
+	for len(data) > 0 {
+		n := 1000
+		if n > len(data) {
+			n = len(data)
+		}
+		q.Append(data[:n], n)
+		s.write(data[:n])
+		data = data[n:]
+		for {
+			_, f, pos, ok := s.next()
+			if !ok {
+				break
+			}
+			q.MarkFrame(pos, f.duration())
+		}
+	}
+
+	// frames start at 7, 424, 841, 1258 ...
+	first, _ := q.Start(1<<20, 0)
+	if q.FrameOffset(first) != 7 || first.duration != 3*(1152*time.Second/44100) {
+		t.Errorf("first page: offset %d, duration %s", q.FrameOffset(first), first.duration)
+	}
+	second := first.Next()
+	if q.FrameOffset(second) != 258 || second.buffer[258] != 0xFF {
+		t.Errorf("second page: offset %d", q.FrameOffset(second))
+	}
+
+	// 3 frames of the last pages are enough for 50 ms
+	if start, burst := q.Start(0, 50*time.Millisecond); start != second.Next().Next() || burst != 2011 {
+		t.Errorf("burst of 50 ms starts at %d, %d bytes", start.start, burst)
+	}
+	if start, burst := q.Start(0, 0); start.next != nil || burst != 11 {
+		t.Errorf("no burst starts at %d, %d bytes", start.start, burst)
+	}
+}
@@ -2,3 +2,307 @@
 // Licensed under the Apache License, Version 2.0 (the "License")
 
 package ice
+
+import (
+	"bytes"
+	"strings"
+	"time"
+)
+
+// cMaxFrameHeader - bytes enough to parse header of any supported frame
+const cMaxFrameHeader = 10
+
+// audioFrame - parsed header of compressed audio frame
+type audioFrame struct {
+	// length of the frame including header
+	size int
+	// samples per channel in the frame
+	samples    int
+	sampleRate int
+	channels   int
+	// kbps, 0 if header doesn't define it
+	bitRate int
+}
+
+func (f audioFrame) duration() time.Duration {
+	if f.sampleRate == 0 {
+		return 0
+	}
+	return time.Duration(f.samples) * time.Second / time.Duration(f.sampleRate)
+}
+
+type frameParser func(b []byte) (audioFrame, bool)
+
+// MPEG audio tables, indexed by version (1 - MPEG1, 2 - MPEG2 and 2.5) and layer
+var (
+	mp3BitRates = [2][3][16]int{
+		{
+			{0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448},
+			{0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384},
+			{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320},
+		},
+		{
+			{0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256},
+			{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+			{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+		},
+	}
+	// by version bits: MPEG2.5, reserved, MPEG2, MPEG1
+	mp3SampleRates = [4][3]int{
+		{11025, 12000, 8000},
+		{},
+		{22050, 24000, 16000},
+		{44100, 48000, 32000},
+	}
+	adtsSampleRates = []int{96000, 88200, 64000, 48000, 44100, 32000, 24000, 22050, 16000, 12000, 11025, 8000, 7350}
+)
+
+// parseMP3Frame - parses MPEG audio (layer I, II or III) frame header at the beginning of b
+func parseMP3Frame(b []byte) (audioFrame, bool) {
+	var f audioFrame
+	if len(b) < 4 || b[0] != 0xFF || b[1]&0xE0 != 0xE0 {
+		return f, false
+	}
+	version := int(b[1]>>3) & 3
+	layer := 4 - int(b[1]>>1)&3
+	bitRateIdx := int(b[2] >> 4)
+	sampleRateIdx := int(b[2]>>2) & 3
+	padding := int(b[2]>>1) & 1
+	if version == 1 || layer == 4 || bitRateIdx == 0 || bitRateIdx == 15 || sampleRateIdx == 3 {
+		return f, false
+	}
+
+	table := 0
+	if version != 3 {
+		table = 1
+	}
+	f.bitRate = mp3BitRates[table][layer-1][bitRateIdx]
+	f.sampleRate = mp3SampleRates[version][sampleRateIdx]
+	f.channels = 2
+	if b[3]>>6 == 3 {
+		f.channels = 1
+	}
+	switch {
+	case layer == 1:
+		f.samples = 384
+		f.size = (12*f.bitRate*1000/f.sampleRate + padding) * 4
+	case layer == 3 && version != 3:
+		f.samples = 576
+		f.size = 72*f.bitRate*1000/f.sampleRate + padding
+	default:
+		f.samples = 1152
+		f.size = 144*f.bitRate*1000/f.sampleRate + padding
+	}
+	return f, true
+}
+
+// parseADTSFrame - parses AAC ADTS frame header at the beginning of b
+func parseADTSFrame(b []byte) (audioFrame, bool) {
+	var f audioFrame
+	// sync word and layer 0
+	if len(b) < 7 || b[0] != 0xFF || b[1]&0xF6 != 0xF0 {
+		return f, false
+	}
+	sampleRateIdx := int(b[2]>>2) & 0xF
+	if sampleRateIdx >= len(adtsSampleRates) {
+		return f, false
+	}
+	f.sampleRate = adtsSampleRates[sampleRateIdx]
+	f.channels = int(b[2]&1)<<2 | int(b[3]>>6)
+	f.size = int(b[3]&3)<<11 | int(b[4])<<3 | int(b[5]>>5)
+	f.samples = 1024 * (int(b[6]&3) + 1)
+	headerSize := 7
+	if b[1]&1 == 0 {
+		// crc follows the header
+		headerSize = 9
+	}
+	if f.size <= headerSize {
+		return f, false
+	}
+	return f, true
+}
+
+// codecs, which stream could be parsed for. Ogg is the container of unknown codec
+const (
+	codecMP3    = "mp3"
+	codecAAC    = "aac"
+	codecOgg    = "ogg"
+	codecVorbis = "vorbis"
+	codecOpus   = "opus"
+	codecFLAC   = "flac"
+)
+
+// cMaxProbeSize - bytes of the stream to look for its codec in
+const cMaxProbeSize = 64 * 1024
+
+// content types of streams by codec
+var codecContentTypes = map[string]string{
+	codecMP3:    "audio/mpeg",
+	codecAAC:    "audio/aac",
+	codecOgg:    "application/ogg",
+	codecVorbis: "audio/ogg",
+	codecOpus:   "audio/ogg",
+	codecFLAC:   "audio/flac",
+}
+
+// codecOf - returns codec declared by content type, empty string if it's unknown
+func codecOf(contentType string) string {
+	contentType = strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	switch contentType {
+	case "audio/mpeg", "audio/mp3", "audio/mpeg3":
+		return codecMP3
+	case "audio/aac", "audio/aacp", "audio/x-aac":
+		return codecAAC
+	case "application/ogg", "audio/ogg", "audio/vorbis", "audio/opus":
+		return codecOgg
+	case "audio/flac", "audio/x-flac":
+		return codecFLAC
+	}
+	return ""
+}
+
+// declaredAs - returns codec, which content type of the stream declares, Ogg for codecs in Ogg container
+func declaredAs(codec string) string {
+	if codec == codecVorbis || codec == codecOpus {
+		return codecOgg
+	}
+	return codec
+}
+
+// detectCodec - identifies codec by the beginning of the stream, returns empty string if it's unknown
+// or there is no enough data yet
+func detectCodec(b []byte) string {
+	// MP3 streams could begin with ID3 tag
+	if len(b) >= 10 && bytes.HasPrefix(b, []byte("ID3")) {
+		size := 10 + (int(b[6]&0x7F)<<21 | int(b[7]&0x7F)<<14 | int(b[8]&0x7F)<<7 | int(b[9]&0x7F))
+		if len(b) < size {
+			return ""
+		}
+		b = b[size:]
+	}
+	switch {
+	case bytes.HasPrefix(b, flacMarker):
+		return codecFLAC
+	case bytes.HasPrefix(b, oggCapture):
+		if len(b) < cOggHeaderSize || len(b) < cOggHeaderSize+int(b[26])+8 {
+			return ""
+		}
+		packet := b[cOggHeaderSize+int(b[26]):]
+		switch {
+		case bytes.HasPrefix(packet, []byte("OpusHead")):
+			return codecOpus
+		case bytes.HasPrefix(packet, []byte("\x01vorbis")):
+			return codecVorbis
+		}
+		return codecOgg
+	}
+	// two frames in a row
+	for i := bytes.IndexByte(b, 0xFF); i >= 0 && i+cMaxFrameHeader <= len(b); i++ {
+		if b[i] != 0xFF {
+			continue
+		}
+		for _, codec := range []string{codecMP3, codecAAC} {
+			parse := frameParserFor(codec)
+			if f, ok := parse(b[i:]); ok && i+f.size+cMaxFrameHeader <= len(b) {
+				if _, ok = parse(b[i+f.size:]); ok {
+					return codec
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// frameParserFor - returns parser of codec's frames, nil if the codec isn't framed by the server
+func frameParserFor(codec string) frameParser {
+	switch codec {
+	case codecMP3:
+		return parseMP3Frame
+	case codecAAC:
+		return parseADTSFrame
+	}
+	return nil
+}
+
+// audioFormat - format of the stream detected by its frames
+type audioFormat struct {
+	Codec      string
+	SampleRate int
+	Channels   int
+	// average bitrate in kbps
+	BitRate int
+}
+
+// formatDetector - detects format and average bitrate of the stream by its frames
+type formatDetector struct {
+	format   audioFormat
+	bytes    int64
+	duration time.Duration
+}
+
+func (d *formatDetector) reset(codec string) {
+	*d = formatDetector{format: audioFormat{Codec: codec}}
+}
+
+func (d *formatDetector) add(f audioFrame) {
+	if f.sampleRate == 0 {
+		return
+	}
+	d.format.SampleRate = f.sampleRate
+	d.format.Channels = f.channels
+	d.bytes += int64(f.size)
+	d.duration += f.duration()
+	if d.duration > 0 {
+		d.format.BitRate = int(float64(d.bytes)*8/d.duration.Seconds()/1000 + 0.5)
+	}
+}
+
+// frameSplitter - splits stream of compressed audio into frames, skipping garbage (like ID3 tags) between them.
+// Frame is accepted only if it's followed by another valid header, so random sync-like bytes are not taken for frames
+type frameSplitter struct {
+	parse   frameParser
+	pending []byte
+	// position of pending data in the stream
+	offset int64
+}
+
+func (s *frameSplitter) reset(parse frameParser) {
+	s.parse = parse
+	s.pending = s.pending[:0]
+}
+
+func (s *frameSplitter) write(data []byte) {
+	s.pending = append(s.pending, data...)
+}
+
+// next - returns the next complete frame, its header and position in the stream, false if more data is needed.
+// Frame stays valid until the next write
+func (s *frameSplitter) next() ([]byte, audioFrame, int64, bool) {
+	for len(s.pending) >= cMaxFrameHeader {
+		f, ok := s.parse(s.pending)
+		if ok {
+			if len(s.pending) < f.size+cMaxFrameHeader {
+				break
+			}
+			if _, ok = s.parse(s.pending[f.size:]); ok {
+				frame := s.pending[:f.size]
+				pos := s.offset
+				s.skip(f.size)
+				return frame, f, pos, true
+			}
+		}
+		// look for the next sync byte
+		idx := bytes.IndexByte(s.pending[1:], 0xFF)
+		if idx == -1 {
+			s.skip(len(s.pending))
+			break
+		}
+		s.skip(idx + 1)
+	}
+	return nil, audioFrame{}, 0, false
+}
+
+func (s *frameSplitter) skip(n int) {
+	s.pending = s.pending[n:]
+	s.offset += int64(n)
+}
@@ -0,0 +1,38 @@
+// Copyright 2019 Setin Sergei
+// Licensed under the Apache License, Version 2.0 (the "License")
+
+package ice
+
+import (
+	"testing"
+)
+
+func TestDetectCodec(t *testing.T) {
+	adts := make([]byte, 371)
+	copy(adts, []byte{0xFF, 0xF1, 0x50, 0x80, 0x2E, 0x7F, 0xFC})
+	_, flac := testFLACStream(1)
+	opus := testOggPage(true, 0, 1, []byte("OpusHead\x01\x02\x38\x01\x80\xbb\x00\x00\x00\x00\x00"))
+	vorbis := testOggPage(true, 0, 1, []byte("\x01vorbis\x00\x00\x00\x00\x02\x44\xac\x00\x00"))
+	cases := []struct {
+		data  []byte
+		codec string
+	}{
+		{testMP3Frames(3), codecMP3},
+		{append([]byte("ID3\x04\x00\x00\x00\x00\x00\x02xx"), testMP3Frames(3)...), codecMP3},
+		{append(append(adts, adts...), adts...), codecAAC},
+		{flac, codecFLAC},
+		{opus, codecOpus},
+		{vorbis, codecVorbis},
+		{opus[:30], ""},
+		{testMP3Frames(1), ""},
+		{[]byte("<html>not an audio stream</html>"), ""},
+	}
+	for i, c := range cases {
+		if codec := detectCodec(c.data); codec != c.codec {
+			t.Errorf("case %d: got %q, want %q", i, codec, c.codec)
+		}
+	}
+	if declaredAs(codecOpus) != codecOf("audio/ogg") || declaredAs(codecFLAC) != codecOf("audio/x-flac") {
+		t.Error("codecs in Ogg container or FLAC don't match their content types")
+	}
+}
@@ -4,7 +4,13 @@
 package ice
 
 import (
+	"fmt"
 	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/ssetin/PenguinCast/src/log"
 
@@ -19,6 +25,8 @@ type options struct {
 
 	Socket struct {
 		Port int `yaml:"Port"`
+		// accept shoutcast v1 sources on Port+1
+		Shoutcast bool `yaml:"Shoutcast"`
 	} `yaml:"Socket"`
 
 	Limits struct {
@@ -27,10 +35,21 @@ type options struct {
 		SourceIdleTimeOut      int   `yaml:"SourceIdleTimeOut"`
 		EmptyBufferIdleTimeOut int   `yaml:"EmptyBufferIdleTimeOut"`
 		WriteTimeOut           int   `yaml:"WriteTimeOut"`
+		// maximum concurrent connections from one address, 0 - unlimited
+		ConnectionsPerIP int `yaml:"ConnectionsPerIP"`
 	} `yaml:"Limits"`
 
+	Access struct {
+		accessOptions `yaml:",inline"`
+		// file to keep addresses banned by admin in
+		BanFile string `yaml:"BanFile"`
+	} `yaml:"Access"`
+
 	Auth struct {
+		AdminUser     string `yaml:"AdminUser"`
 		AdminPassword string `yaml:"AdminPassword"`
+		// key of ultravox sources authentication
+		UltravoxCipherKey string `yaml:"UltravoxCipherKey"`
 	} `yaml:"Auth"`
 
 	Paths struct {
@@ -48,13 +67,201 @@ type options struct {
 		StatInterval    int           `yaml:"StatInterval"`
 	} `yaml:"Logging"`
 
+	// HTTP live streaming of mounts: duration of segments, sec and number of segments in playlist
+	HLS struct {
+		SegmentDuration int `yaml:"SegmentDuration"`
+		Segments        int `yaml:"Segments"`
+	} `yaml:"HLS"`
+
+	// master server to mirror mounts from
+	Master masterOptions `yaml:"Master"`
+
 	Mounts []*mount `yaml:"Mounts"`
+	// templates of mounts, which are created when source connects to the path matching template's Name
+	Templates []*mount `yaml:"Templates"`
+
+	// overrides - config options which were taken from environment variables, option path -> variable name
+	overrides map[string]string
+	// for reporting line numbers of wrong options
+	fileName string
+	root     *yaml.Node
+}
+
+// masterOptions - master server, which stream list is polled, and options of relay mounts created for its streams
+type masterOptions struct {
+	URL            string `yaml:"URL"`
+	User           string `yaml:"User"`
+	Password       string `yaml:"Password"`
+	UpdateInterval int    `yaml:"UpdateInterval"`
+	BitRate        int    `yaml:"BitRate"`
+	BurstSize      int    `yaml:"BurstSize"`
+	BurstDuration  int    `yaml:"BurstDuration"`
+	RelayMetadata  bool   `yaml:"RelayMetadata"`
+	RelayOnDemand  bool   `yaml:"RelayOnDemand"`
 }
 
-func (o *options) Load() error {
-	yamlFile, err := ioutil.ReadFile("config.yaml")
+const cEnvPrefix = "PENGUIN_"
+
+// Load - reads config from fileName, applies environment overrides to it and validates the result
+func (o *options) Load(fileName string) error {
+	yamlFile, err := ioutil.ReadFile(fileName)
 	if err != nil {
 		return err
 	}
-	return yaml.Unmarshal(yamlFile, o)
+	o.fileName = fileName
+	o.root = &yaml.Node{}
+	err = yaml.Unmarshal(yamlFile, o.root)
+	if err != nil {
+		return fmt.Errorf("%s: %s", fileName, err.Error())
+	}
+	if len(o.root.Content) > 0 {
+		err = o.root.Decode(o)
+		if err != nil {
+			return fmt.Errorf("%s: %s", fileName, err.Error())
+		}
+	}
+	err = o.applyEnv(os.Environ())
+	if err != nil {
+		return err
+	}
+	if o.Auth.AdminUser == "" {
+		o.Auth.AdminUser = "admin"
+	}
+	if o.Auth.UltravoxCipherKey == "" {
+		// default key of shoutcast encoders
+		o.Auth.UltravoxCipherKey = "foobar"
+	}
+	if o.HLS.SegmentDuration == 0 {
+		o.HLS.SegmentDuration = 6
+	}
+	if o.HLS.Segments == 0 {
+		o.HLS.Segments = 5
+	}
+	if o.Master.User == "" {
+		o.Master.User = "admin"
+	}
+	if o.Master.UpdateInterval == 0 {
+		o.Master.UpdateInterval = 120
+	}
+	return o.validate()
+}
+
+// CheckConfig - loads and validates config file without starting the server
+func CheckConfig(fileName string) error {
+	var o options
+	return o.Load(fileName)
+}
+
+// applyEnv - overrides options by environment variables like PENGUIN_SOCKET_PORT or PENGUIN_MOUNTS_0_PASSWORD.
+// Path elements are yaml names of options in upper case, slice elements are addressed by index
+func (o *options) applyEnv(environ []string) error {
+	for _, env := range environ {
+		if !strings.HasPrefix(env, cEnvPrefix) {
+			continue
+		}
+		kv := strings.SplitN(env, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		path := strings.Split(strings.TrimPrefix(kv[0], cEnvPrefix), "_")
+		name, err := setOption(reflect.ValueOf(o).Elem(), path, kv[1])
+		if err != nil {
+			return fmt.Errorf("%s: %s", kv[0], err.Error())
+		}
+		if o.overrides == nil {
+			o.overrides = make(map[string]string)
+		}
+		o.overrides[name] = kv[0]
+	}
+	return nil
+}
+
+// setOption - sets value of the option addressed by path, returns its yaml path
+func setOption(v reflect.Value, path []string, value string) (string, error) {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return "", fmt.Errorf("option is not defined")
+		}
+		v = v.Elem()
+	}
+
+	if len(path) == 0 {
+		return "", setValue(v, value)
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		t := v.Type()
+		for idx := 0; idx < t.NumField(); idx++ {
+			if t.Field(idx).Anonymous {
+				// inlined struct, its options are looked up at the same level
+				if name, err := setOption(v.Field(idx), path, value); err == nil {
+					return name, nil
+				}
+				continue
+			}
+			tag := strings.Split(t.Field(idx).Tag.Get("yaml"), ",")[0]
+			if tag == "" || tag == "-" || !strings.EqualFold(tag, path[0]) {
+				continue
+			}
+			name, err := setOption(v.Field(idx), path[1:], value)
+			if err != nil || name == "" {
+				return tag, err
+			}
+			return tag + "." + name, nil
+		}
+		return "", fmt.Errorf("unknown option %s", path[0])
+	case reflect.Slice:
+		idx, err := strconv.Atoi(path[0])
+		if err != nil || idx < 0 || idx >= v.Len() {
+			return "", fmt.Errorf("wrong index %s", path[0])
+		}
+		name, err := setOption(v.Index(idx), path[1:], value)
+		if err != nil || name == "" {
+			return path[0], err
+		}
+		return path[0] + "." + name, nil
+	}
+	return "", fmt.Errorf("unknown option %s", strings.Join(path, "_"))
+}
+
+func setValue(v reflect.Value, value string) error {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("option could not be set from environment")
+		}
+		items := strings.Split(value, ",")
+		for idx := range items {
+			items[idx] = strings.TrimSpace(items[idx])
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("option could not be set from environment")
+	}
+	return nil
+}
+
+// overridesList - returns sorted list of overridden options for reporting
+func (o *options) overridesList() []string {
+	result := make([]string, 0, len(o.overrides))
+	for name, env := range o.overrides {
+		result = append(result, name+" is overridden by "+env)
+	}
+	sort.Strings(result)
+	return result
 }
@@ -0,0 +1,152 @@
+// Copyright 2019 Setin Sergei
+// Licensed under the Apache License, Version 2.0 (the "License")
+
+package ice
+
+import (
+	"net/http"
+	"path"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// how often dynamic mount checks, whether its listeners have left
+const cTeardownInterval = time.Second
+
+// initTemplates - prepares templates for checking credentials and access of sources and makes them current
+func (i *Server) initTemplates(templates []*mount) error {
+	for _, t := range templates {
+		access, err := newAccessRules(t.accessOptions)
+		if err != nil {
+			return err
+		}
+		t.server = i
+		t.logger = i.logger
+		t.access = access
+	}
+	i.mux.Lock()
+	i.Options.Templates = templates
+	i.mux.Unlock()
+	return nil
+}
+
+// findTemplate - returns the first template, which Name pattern matches mount name
+func (i *Server) findTemplate(name string) *mount {
+	name = strings.TrimPrefix(name, "/")
+	i.mux.Lock()
+	templates := i.Options.Templates
+	i.mux.Unlock()
+	for _, t := range templates {
+		if ok, _ := path.Match(t.Name, name); ok {
+			return t
+		}
+	}
+	return nil
+}
+
+func (i *Server) sourceMatcher(r *http.Request, rm *mux.RouteMatch) bool {
+	return i.getMount(r.URL.Path) != nil || i.findTemplate(r.URL.Path) != nil
+}
+
+// dynamicSource - serves source of the path matching template. Source is checked by the mount it would create,
+// and the mount is added only if source is admitted
+func (i *Server) dynamicSource(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/")
+	if msg := checkMountName(name); msg > "" {
+		i.logger.Error("Source of %s: %s", r.URL.Path, msg)
+		http.NotFound(w, r)
+		return
+	}
+	t := i.findTemplate(name)
+	if t == nil {
+		i.logger.Error("Source of %s: no template for mount", r.URL.Path)
+		http.NotFound(w, r)
+		return
+	}
+	m := i.dynamicMount(name, t, r)
+	host, ok := m.admitSource(w, r)
+	if !ok {
+		return
+	}
+
+	added, err := i.addDynamicMount(m, t)
+	if err != nil {
+		i.releaseConnection(host)
+		i.logger.Error("Source of %s: %s", m.Name, err.Error())
+		http.Error(w, "Mount could not be created", http.StatusInternalServerError)
+		return
+	}
+	if added != m {
+		// another source or reload has created the mount meanwhile, source has to be checked by it
+		i.releaseConnection(host)
+		added.write(w, r)
+	} else {
+		m.stream(w, r)
+		i.releaseConnection(host)
+	}
+	if added.isDynamic() {
+		i.teardown(added)
+	}
+}
+
+// dynamicMount - prepares mount for the source by template, bitrate is taken from source headers.
+// Mount isn't initialized and added until source is admitted
+func (i *Server) dynamicMount(name string, t *mount, r *http.Request) *mount {
+	t.mux.Lock()
+	m := &mount{
+		Name:          name,
+		Description:   t.Description,
+		Genre:         t.Genre,
+		BitRate:       t.BitRate,
+		BurstSize:     t.BurstSize,
+		BurstDuration: t.BurstDuration,
+		server:        i,
+		logger:        t.logger,
+	}
+	t.mux.Unlock()
+	m.update(t)
+	m.dynamic = true
+	if bitRate := m.sourceBitRate(r); bitRate > 0 {
+		m.BitRate = bitRate
+	}
+	return m
+}
+
+// addDynamicMount - initializes and adds mount of admitted source, returns the existing mount instead,
+// if it has been created meanwhile
+func (i *Server) addDynamicMount(m *mount, t *mount) (*mount, error) {
+	i.reloadMux.Lock()
+	defer i.reloadMux.Unlock()
+	if existing := i.getMount(m.Name); existing != nil {
+		return existing, nil
+	}
+	if err := m.Init(i, i.logger, i.poolManager); err != nil {
+		return nil, err
+	}
+	i.addMount(m)
+	i.logger.Log("Mount %s added by template %s", m.Name, t.Name)
+	return m, nil
+}
+
+// teardown - removes dynamic mount, when its source has left and listeners have drained the buffer.
+// Mount is kept if another source connects to it meanwhile
+func (i *Server) teardown(m *mount) {
+	for {
+		m.mux.Lock()
+		if m.State.Started || m.retired {
+			m.mux.Unlock()
+			return
+		}
+		if atomic.LoadInt32(&m.State.Listeners) == 0 {
+			m.retired = true
+			m.mux.Unlock()
+			i.removeMount(m)
+			return
+		}
+		m.mux.Unlock()
+		time.Sleep(cTeardownInterval)
+	}
+}
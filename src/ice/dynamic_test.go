@@ -0,0 +1,47 @@
+// Copyright 2019 Setin Sergei
+// Licensed under the Apache License, Version 2.0 (the "License")
+
+package ice
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDynamicSourceAuth(t *testing.T) {
+	srv := &Server{logger: nopLogger{}, bans: &banList{}}
+	srv.Options.Limits.Sources = 1
+	template := &mount{Name: "live/*", User: "source", Password: "secret", BitRate: 128}
+	template.SourceDeny = []string{"192.0.2.0/24"}
+	if err := srv.initTemplates([]*mount{template}); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name     string
+		addr     string
+		password string
+		want     int
+	}{
+		{"no credentials", "198.51.100.1:5000", "", http.StatusUnauthorized},
+		{"wrong password", "198.51.100.1:5000", "wrong", http.StatusUnauthorized},
+		{"denied address", "192.0.2.1:5000", "secret", http.StatusForbidden},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("PUT", "/live/show", nil)
+		r.RemoteAddr = c.addr
+		r.Header.Set("ice-bitrate", "2000000000")
+		if c.password > "" {
+			r.SetBasicAuth("source", c.password)
+		}
+		w := httptest.NewRecorder()
+		srv.sourceHandler(w, r)
+		if w.Code != c.want {
+			t.Errorf("%s: got %d, want %d", c.name, w.Code, c.want)
+		}
+	}
+	if len(srv.Mounts()) != 0 || srv.connections["198.51.100.1"]+srv.connections["192.0.2.1"] != 0 {
+		t.Errorf("rejected sources left %d mounts and connections %v", len(srv.Mounts()), srv.connections)
+	}
+}
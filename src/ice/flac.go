@@ -0,0 +1,228 @@
+// Copyright 2019 Setin Sergei
+// Licensed under the Apache License, Version 2.0 (the "License")
+
+package ice
+
+import (
+	"bytes"
+)
+
+const (
+	// sync code, reserved bit and blocking strategy, fixed header, utf-8 coded number, block size, sample rate and crc
+	cFLACMaxFrameHeader = 2 + 2 + 7 + 2 + 2 + 1
+	cFLACBlockHeader    = 4
+	cFLACStreamInfo     = 0
+)
+
+var (
+	flacMarker = []byte("fLaC")
+	// by sample rate code of frame header, 0 - from STREAMINFO
+	flacSampleRates = []int{0, 88200, 176400, 192000, 8000, 16000, 22050, 24000, 32000, 44100, 48000, 96000}
+)
+
+// crc8 - crc of FLAC frame header, polynomial x^8 + x^2 + x^1 + x^0
+func crc8(b []byte) byte {
+	var crc byte
+	for _, c := range b {
+		crc ^= c
+		for i := 0; i < 8; i++ {
+			if crc&0x80 != 0 {
+				crc = crc<<1 ^ 0x07
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// parseFLACFrame - parses FLAC frame header at the beginning of b. Size of the frame isn't known from its header.
+// Sample rate and channels of the stream are used, when header refers to STREAMINFO
+func parseFLACFrame(b []byte, sampleRate, channels int) (audioFrame, bool) {
+	var f audioFrame
+	if len(b) < cFLACMaxFrameHeader || b[0] != 0xFF || b[1]&0xFE != 0xF8 {
+		return f, false
+	}
+	blockSizeCode := int(b[2] >> 4)
+	sampleRateCode := int(b[2] & 0xF)
+	channelsCode := int(b[3] >> 4)
+	if blockSizeCode == 0 || sampleRateCode == 15 || channelsCode > 10 || b[3]>>1&7 == 3 || b[3]&1 != 0 {
+		return f, false
+	}
+
+	// utf-8 like coded frame or sample number
+	n := 0
+	switch c := b[4]; {
+	case c < 0x80:
+		n = 1
+	case c&0xE0 == 0xC0:
+		n = 2
+	case c&0xF0 == 0xE0:
+		n = 3
+	case c&0xF8 == 0xF0:
+		n = 4
+	case c&0xFC == 0xF8:
+		n = 5
+	case c&0xFE == 0xFC:
+		n = 6
+	case c == 0xFE:
+		n = 7
+	default:
+		return f, false
+	}
+	i := 4 + n
+
+	switch {
+	case blockSizeCode == 1:
+		f.samples = 192
+	case blockSizeCode <= 5:
+		f.samples = 576 << uint(blockSizeCode-2)
+	case blockSizeCode == 6:
+		f.samples = int(b[i]) + 1
+		i++
+	case blockSizeCode == 7:
+		f.samples = (int(b[i])<<8 | int(b[i+1])) + 1
+		i += 2
+	default:
+		f.samples = 256 << uint(blockSizeCode-8)
+	}
+
+	switch {
+	case sampleRateCode == 0:
+		f.sampleRate = sampleRate
+	case sampleRateCode < len(flacSampleRates):
+		f.sampleRate = flacSampleRates[sampleRateCode]
+	case sampleRateCode == 12:
+		f.sampleRate = int(b[i]) * 1000
+		i++
+	case sampleRateCode == 13:
+		f.sampleRate = int(b[i])<<8 | int(b[i+1])
+		i += 2
+	default:
+		f.sampleRate = (int(b[i])<<8 | int(b[i+1])) * 10
+		i += 2
+	}
+
+	f.channels = channelsCode + 1
+	if channelsCode >= 8 {
+		// left/side, right/side and mid/side stereo
+		f.channels = 2
+	}
+	if channels > 0 && f.channels != channels {
+		return f, false
+	}
+	if crc8(b[:i]) != b[i] {
+		return f, false
+	}
+	return f, true
+}
+
+// flacStream - splits native FLAC stream into frames and keeps its metadata blocks, which have to be sent
+// to listeners before any frame
+type flacStream struct {
+	active  bool
+	pending []byte
+	// position of pending data in the stream
+	offset int64
+
+	headers     []byte
+	headersDone bool
+	// from STREAMINFO block
+	sampleRate int
+	channels   int
+	// frame is complete, when the next one is found
+	last    audioFrame
+	lastPos int64
+}
+
+func (s *flacStream) reset(active bool) {
+	s.active = active
+	s.pending = s.pending[:0]
+	s.headers = nil
+	s.headersDone = false
+	s.sampleRate = 0
+	s.channels = 0
+	s.lastPos = -1
+}
+
+func (s *flacStream) write(data []byte) {
+	s.pending = append(s.pending, data...)
+}
+
+func (s *flacStream) skip(n int) {
+	s.pending = s.pending[n:]
+	s.offset += int64(n)
+}
+
+// next - returns the next complete frame and its position in the stream, or true as header, when metadata
+// blocks are received. False if more data is needed
+func (s *flacStream) next() (audioFrame, int64, bool, bool) {
+	if !s.headersDone && s.readHeaders() {
+		return audioFrame{}, 0, true, true
+	}
+	if !s.headersDone {
+		return audioFrame{}, 0, false, false
+	}
+
+	for len(s.pending) >= cFLACMaxFrameHeader {
+		if f, ok := parseFLACFrame(s.pending, s.sampleRate, s.channels); ok {
+			last, lastPos := s.last, s.lastPos
+			s.last, s.lastPos = f, s.offset
+			// there could be no frame, which is shorter than its sync code and header
+			s.skip(2)
+			if lastPos >= 0 {
+				last.size = int(s.lastPos - lastPos)
+				return last, lastPos, false, true
+			}
+			continue
+		}
+		idx := bytes.IndexByte(s.pending[1:], 0xFF)
+		if idx == -1 {
+			s.skip(len(s.pending))
+			break
+		}
+		s.skip(idx + 1)
+	}
+	return audioFrame{}, 0, false, false
+}
+
+// readHeaders - moves metadata blocks from pending data to headers, returns true when the last one is read
+func (s *flacStream) readHeaders() bool {
+	if len(s.headers) == 0 {
+		if len(s.pending) < len(flacMarker) {
+			return false
+		}
+		if !bytes.HasPrefix(s.pending, flacMarker) {
+			// stream without metadata, frames are looked for
+			s.headersDone = true
+			return false
+		}
+		s.headers = append(s.headers, flacMarker...)
+		s.skip(len(flacMarker))
+	}
+	for len(s.pending) >= cFLACBlockHeader {
+		size := cFLACBlockHeader + (int(s.pending[1])<<16 | int(s.pending[2])<<8 | int(s.pending[3]))
+		if len(s.pending) < size {
+			return false
+		}
+		block := s.pending[:size]
+		if block[0]&0x7F == cFLACStreamInfo && size >= cFLACBlockHeader+13 {
+			info := block[cFLACBlockHeader:]
+			s.sampleRate = int(info[10])<<12 | int(info[11])<<4 | int(info[12]>>4)
+			s.channels = int(info[12]>>1&7) + 1
+		}
+		s.headers = append(s.headers, block...)
+		s.skip(size)
+		if block[0]&0x80 != 0 {
+			s.headersDone = true
+			return true
+		}
+	}
+	return false
+}
+
+// header - returns metadata blocks of the stream
+func (s *flacStream) header() []byte {
+	// listeners append the burst to it, so it mustn't share capacity with data to come
+	return s.headers[:len(s.headers):len(s.headers)]
+}
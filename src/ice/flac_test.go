@@ -0,0 +1,55 @@
+// Copyright 2019 Setin Sergei
+// Licensed under the Apache License, Version 2.0 (the "License")
+
+package ice
+
+import (
+	"bytes"
+	"testing"
+)
+
+// testFLACStream - metadata with STREAMINFO (44100 Hz, stereo) and frames of 4096 samples, 1000 bytes each
+func testFLACStream(frames int) ([]byte, []byte) {
+	info := make([]byte, 34)
+	// 44100 Hz, 2 channels, 16 bits
+	copy(info[10:], []byte{0x0A, 0xC4, 0x42, 0xF0})
+	header := append([]byte("fLaC\x80\x00\x00\x22"), info...)
+
+	data := append([]byte{}, header...)
+	for i := 0; i < frames; i++ {
+		frame := make([]byte, 1000)
+		copy(frame, []byte{0xFF, 0xF8, 0xC9, 0x18, byte(i)})
+		frame[5] = crc8(frame[:5])
+		data = append(data, frame...)
+	}
+	return header, data
+}
+
+func TestFLACStream(t *testing.T) {
+	header, data := testFLACStream(5)
+	var s flacStream
+	s.reset(true)
+	s.write(data[:20])
+	if _, _, _, ok := s.next(); ok {
+		t.Fatal("incomplete metadata is accepted")
+	}
+	s.write(data[20:])
+	if _, _, h, ok := s.next(); !ok || !h || !bytes.Equal(s.header(), header) {
+		t.Fatalf("metadata is not read: %v", h)
+	}
+	frames := 0
+	for {
+		f, pos, _, ok := s.next()
+		if !ok {
+			break
+		}
+		if pos != int64(len(header)+frames*1000) || f.size != 1000 || f.samples != 4096 || f.sampleRate != 44100 || f.channels != 2 {
+			t.Fatalf("wrong frame %d at %d: %+v", frames, pos, f)
+		}
+		frames++
+	}
+	// the last frame waits for the next one
+	if frames != 4 {
+		t.Errorf("got %d frames, want 4", frames)
+	}
+}
@@ -4,11 +4,26 @@
 package ice
 
 import (
+	"encoding/json"
 	"html/template"
 	"io/ioutil"
 	"net/http"
+	"time"
 )
 
+// statusJSON - icecast compatible server status, /status-json.xsl
+type statusJSON struct {
+	IceStats struct {
+		Admin              string        `json:"admin"`
+		Host               string        `json:"host"`
+		Location           string        `json:"location"`
+		ServerID           string        `json:"server_id"`
+		ServerStart        string        `json:"server_start"`
+		ServerStartISO8601 string        `json:"server_start_iso8601"`
+		Source             []sourceStats `json:"source"`
+	} `json:"icestats"`
+}
+
 func (i *Server) internalHandler(w http.ResponseWriter, r *http.Request) {
 	f, _ := ioutil.ReadFile(i.Options.Paths.Web + "500.html")
 	w.WriteHeader(http.StatusInternalServerError)
@@ -20,7 +35,29 @@ func (i *Server) infoHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func (i *Server) jsonHandler(w http.ResponseWriter, r *http.Request) {
-	i.renderPage(w, r, "templates/json.gohtml")
+	var status statusJSON
+	i.mux.Lock()
+	status.IceStats.Admin = i.Options.Admin
+	status.IceStats.Host = i.Options.Host
+	status.IceStats.Location = i.Options.Location
+	status.IceStats.ServerID = i.serverName + " " + i.version
+	status.IceStats.ServerStart = i.StartedTime.Format(time.RFC1123Z)
+	status.IceStats.ServerStartISO8601 = i.StartedTime.Format(cISO8601)
+	i.mux.Unlock()
+
+	status.IceStats.Source = make([]sourceStats, 0)
+	for _, m := range i.Mounts() {
+		if !m.isStarted() {
+			continue
+		}
+		status.IceStats.Source = append(status.IceStats.Source, m.getSourceStats())
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		i.logger.Error(err.Error())
+	}
 }
 
 func (i *Server) monitorHandler(w http.ResponseWriter, r *http.Request) {
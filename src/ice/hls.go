@@ -0,0 +1,305 @@
+// Copyright 2019 Setin Sergei
+// Licensed under the Apache License, Version 2.0 (the "License")
+
+package ice
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	cHLSPlaylist     = "index.m3u8"
+	cHLSPlaylistType = "application/vnd.apple.mpegurl"
+	// clock of timestamps in ID3 tags of packed audio segments, they are 33-bit as in MPEG-2 transport stream
+	cHLSTimescale      = 90000
+	cHLSTimestampMask  = 1<<33 - 1
+	cID3TimestampOwner = "com.apple.streaming.transportStreamTimestamp"
+)
+
+// hlsSegment - packed audio segment: ID3 tag with timestamp followed by audio frames
+type hlsSegment struct {
+	sequence int
+	duration float64
+	// stream was restarted before this segment
+	discontinuity bool
+	data          []byte
+}
+
+// hlsStream - cuts stream of the mount into rolling HTTP live streaming segments on frame boundaries
+type hlsStream struct {
+	mux         sync.Mutex
+	extension   string
+	contentType string
+	// options of the current source
+	segmentDuration int
+	maxSegments     int
+
+	// segment being built and its duration in samples
+	current        []byte
+	currentSamples int
+	sampleRate     int
+	// timestamp is base (in 90kHz units) plus samples since sample rate was set
+	baseTimestamp int64
+	samples       int64
+	title         string
+	discontinuity bool
+
+	segments []*hlsSegment
+	// sequence of the next segment and number of discontinuities, which have left the playlist
+	sequence              int
+	discontinuitySequence int
+}
+
+// start - prepares segmenter for the new source, HLS is off for codecs, which couldn't be split into frames.
+// Segments of the previous source are kept, so players could go on
+func (s *hlsStream) start(contentType string, segmentDuration, maxSegments int) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	codec := codecOf(contentType)
+	s.extension = ""
+	s.contentType = ""
+	if frameParserFor(codec) != nil {
+		s.extension = "." + codec
+		s.contentType = codecContentTypes[codec]
+	}
+	s.segmentDuration = segmentDuration
+	s.maxSegments = maxSegments
+	s.current = nil
+	s.currentSamples = 0
+	s.discontinuity = len(s.segments) > 0
+}
+
+func (s *hlsStream) timestamp() int64 {
+	if s.sampleRate == 0 {
+		return s.baseTimestamp
+	}
+	return s.baseTimestamp + s.samples*cHLSTimescale/int64(s.sampleRate)
+}
+
+// write - adds frame of the source to the current segment, segment is cut when it's long enough
+func (s *hlsStream) write(frame []byte, f audioFrame) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.extension == "" {
+		return
+	}
+	if f.sampleRate != s.sampleRate {
+		s.baseTimestamp = s.timestamp()
+		s.samples = 0
+		s.sampleRate = f.sampleRate
+	}
+	if len(s.current) == 0 {
+		s.current = id3Tag(s.timestamp(), s.title)
+	}
+	s.current = append(s.current, frame...)
+	s.currentSamples += f.samples
+	s.samples += int64(f.samples)
+	if s.currentSamples >= s.segmentDuration*s.sampleRate {
+		s.cut()
+	}
+}
+
+func (s *hlsStream) cut() {
+	s.segments = append(s.segments, &hlsSegment{
+		sequence:      s.sequence,
+		duration:      float64(s.currentSamples) / float64(s.sampleRate),
+		discontinuity: s.discontinuity,
+		data:          s.current,
+	})
+	s.sequence++
+	s.discontinuity = false
+	s.current = nil
+	s.currentSamples = 0
+	for len(s.segments) > s.maxSegments {
+		if s.segments[0].discontinuity {
+			s.discontinuitySequence++
+		}
+		s.segments = s.segments[1:]
+	}
+}
+
+// setTitle - inserts timed metadata with the new title at the current position of the stream
+func (s *hlsStream) setTitle(title string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if title == s.title {
+		return
+	}
+	s.title = title
+	if len(s.current) > 0 {
+		s.current = append(s.current, id3Tag(s.timestamp(), title)...)
+	}
+}
+
+// playlist - returns live playlist of available segments, false if there are no ones yet
+func (s *hlsStream) playlist() (string, bool) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if len(s.segments) == 0 {
+		return "", false
+	}
+	targetDuration := 0
+	for _, seg := range s.segments {
+		if d := int(math.Ceil(seg.duration)); d > targetDuration {
+			targetDuration = d
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n")
+	fmt.Fprintf(&b, "#EXT-X-TARGETDURATION:%d\n", targetDuration)
+	fmt.Fprintf(&b, "#EXT-X-MEDIA-SEQUENCE:%d\n", s.segments[0].sequence)
+	if s.discontinuitySequence > 0 {
+		fmt.Fprintf(&b, "#EXT-X-DISCONTINUITY-SEQUENCE:%d\n", s.discontinuitySequence)
+	}
+	for _, seg := range s.segments {
+		if seg.discontinuity {
+			b.WriteString("#EXT-X-DISCONTINUITY\n")
+		}
+		fmt.Fprintf(&b, "#EXTINF:%.3f,\n%d%s\n", seg.duration, seg.sequence, s.extension)
+	}
+	return b.String(), true
+}
+
+// segment - returns data of the segment by its file name like 12.mp3
+func (s *hlsStream) segment(name string) ([]byte, string, bool) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.extension == "" || !strings.HasSuffix(name, s.extension) {
+		return nil, "", false
+	}
+	sequence, err := strconv.Atoi(strings.TrimSuffix(name, s.extension))
+	if err != nil {
+		return nil, "", false
+	}
+	for _, seg := range s.segments {
+		if seg.sequence == sequence {
+			return seg.data, s.contentType, true
+		}
+	}
+	return nil, "", false
+}
+
+// id3Tag - ID3v2.4 tag with MPEG-2 transport stream timestamp and title, it's timed metadata of packed audio
+func id3Tag(timestamp int64, title string) []byte {
+	var frames []byte
+	priv := make([]byte, 0, len(cID3TimestampOwner)+9)
+	priv = append(priv, cID3TimestampOwner...)
+	priv = append(priv, 0)
+	var ts [8]byte
+	binary.BigEndian.PutUint64(ts[:], uint64(timestamp&cHLSTimestampMask))
+	priv = append(priv, ts[:]...)
+	frames = appendID3Frame(frames, "PRIV", priv)
+	if title > "" {
+		// UTF-8 encoding
+		frames = appendID3Frame(frames, "TIT2", append([]byte{3}, title...))
+	}
+
+	tag := make([]byte, 0, 10+len(frames))
+	tag = append(tag, 'I', 'D', '3', 4, 0, 0)
+	tag = appendSyncSafe(tag, len(frames))
+	return append(tag, frames...)
+}
+
+func appendID3Frame(b []byte, id string, data []byte) []byte {
+	b = append(b, id...)
+	b = appendSyncSafe(b, len(data))
+	b = append(b, 0, 0)
+	return append(b, data...)
+}
+
+// appendSyncSafe - appends 28-bit size, 7 bits per byte
+func appendSyncSafe(b []byte, size int) []byte {
+	return append(b, byte(size>>21&0x7F), byte(size>>14&0x7F), byte(size>>7&0x7F), byte(size&0x7F))
+}
+
+// hlsRequest - splits path like /mount/index.m3u8 or /mount/12.mp3 into the mount and file name
+func (i *Server) hlsRequest(path string) (*mount, string) {
+	idx := strings.LastIndex(path, "/")
+	if idx <= 0 {
+		return nil, ""
+	}
+	file := path[idx+1:]
+	if file != cHLSPlaylist && !strings.HasSuffix(file, ".mp3") && !strings.HasSuffix(file, ".aac") {
+		return nil, ""
+	}
+	return i.getMount(path[:idx]), file
+}
+
+func (i *Server) hlsMatcher(r *http.Request, rm *mux.RouteMatch) bool {
+	m, _ := i.hlsRequest(r.URL.Path)
+	return m != nil
+}
+
+// admitHLSClient - checks the client the way listeners are checked, counts its connection if it's allowed.
+// HLS clients make a request for every segment, so they couldn't be authorized by listener_add or signed url,
+// mounts requiring it aren't published
+func (m *mount) admitHLSClient(host string) error {
+	m.mux.Lock()
+	restricted := m.SignSecret > "" || m.ListenerAddURL > ""
+	m.mux.Unlock()
+	if restricted {
+		return errors.New("HLS is not available for mounts with listener auth or signed urls")
+	}
+	if !m.server.checkListeners() {
+		return errors.New("number of listeners exceeded")
+	}
+	if !m.checkListeners() {
+		return errors.New("number of listeners of the mount exceeded")
+	}
+	return m.admitClient(host, false)
+}
+
+// hlsHandler - serves playlist and segments of the mount
+func (i *Server) hlsHandler(w http.ResponseWriter, r *http.Request) {
+	m, file := i.hlsRequest(r.URL.Path)
+	if m == nil {
+		http.NotFound(w, r)
+		return
+	}
+	host := i.getHost(r.RemoteAddr)
+	if err := m.admitHLSClient(host); err != nil {
+		m.logger.Error("HLS listener of %s from %s: %s", m.Name, host, err.Error())
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	defer i.releaseConnection(host)
+	// players in browsers request it from other origins
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if !m.isStarted() {
+		http.NotFound(w, r)
+		return
+	}
+	if file == cHLSPlaylist {
+		playlist, ok := m.hls.playlist()
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", cHLSPlaylistType)
+		w.Header().Set("Cache-Control", "no-cache")
+		_, _ = w.Write([]byte(playlist))
+		return
+	}
+
+	data, contentType, ok := m.hls.segment(file)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	n, _ := w.Write(data)
+	atomic.AddInt64(&m.bytesSent, int64(n))
+}
@@ -0,0 +1,153 @@
+// Copyright 2019 Setin Sergei
+// Licensed under the Apache License, Version 2.0 (the "License")
+
+package ice
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// testMP3Frames - MPEG1 layer III frames, 128 kbps, 44100 Hz, 417 bytes each
+func testMP3Frames(count int) []byte {
+	frame := make([]byte, 417)
+	copy(frame, []byte{0xFF, 0xFB, 0x90, 0x64})
+	return bytes.Repeat(frame, count)
+}
+
+func TestParseFrames(t *testing.T) {
+	f, ok := parseMP3Frame([]byte{0xFF, 0xFB, 0x90, 0x64})
+	if !ok || f.size != 417 || f.samples != 1152 || f.sampleRate != 44100 || f.bitRate != 128 || f.channels != 2 {
+		t.Errorf("mp3 frame: %+v, %v", f, ok)
+	}
+	// AAC LC, 44100 Hz, stereo, 371 bytes
+	f, ok = parseADTSFrame([]byte{0xFF, 0xF1, 0x50, 0x80, 0x2E, 0x7F, 0xFC})
+	if !ok || f.size != 371 || f.samples != 1024 || f.sampleRate != 44100 || f.channels != 2 {
+		t.Errorf("adts frame: %+v, %v", f, ok)
+	}
+	if _, ok = parseMP3Frame([]byte{0xFF, 0xFB, 0xF0, 0x64}); ok {
+		t.Error("mp3 frame with bad bitrate index is accepted")
+	}
+}
+
+func TestFrameSplitter(t *testing.T) {
+	var s frameSplitter
+	s.reset(parseMP3Frame)
+	// garbage before the stream and data split in the middle of frames
+	data := append([]byte("ID3\xFF\x00garbage"), testMP3Frames(5)...)
+	s.write(data[:600])
+	s.write(data[600:])
+	frames := 0
+	for {
+		frame, _, pos, ok := s.next()
+		if !ok {
+			break
+		}
+		if len(frame) != 417 || frame[0] != 0xFF || pos != int64(12+frames*417) {
+			t.Fatalf("wrong frame %d at %d: % x", frames, pos, frame[:4])
+		}
+		frames++
+	}
+	// the last frame waits for the next header
+	if frames != 4 {
+		t.Errorf("got %d frames, want 4", frames)
+	}
+}
+
+// writeFrames - passes whole frames of data to HLS segmenter
+func writeFrames(s *hlsStream, data []byte) {
+	f, _ := parseMP3Frame(data)
+	for ; len(data) >= f.size; data = data[f.size:] {
+		s.write(data[:f.size], f)
+	}
+}
+
+func TestHLSPlaylist(t *testing.T) {
+	var s hlsStream
+	s.start("audio/mpeg", 2, 3)
+	// 1152 samples per frame, 77 frames are just over 2 seconds
+	writeFrames(&s, testMP3Frames(77*4+1))
+	playlist, ok := s.playlist()
+	if !ok {
+		t.Fatal("no playlist")
+	}
+	for _, line := range []string{"#EXT-X-TARGETDURATION:3", "#EXT-X-MEDIA-SEQUENCE:1", "#EXTINF:2.011,\n3.mp3"} {
+		if !strings.Contains(playlist, line) {
+			t.Errorf("playlist has no %q:\n%s", line, playlist)
+		}
+	}
+	if strings.Contains(playlist, "\n0.mp3") {
+		t.Errorf("old segment is not removed:\n%s", playlist)
+	}
+
+	data, contentType, ok := s.segment("3.mp3")
+	if !ok || contentType != "audio/mpeg" || !bytes.HasPrefix(data, []byte("ID3")) {
+		t.Fatalf("wrong segment: %s, %v", contentType, ok)
+	}
+	// 3 segments of 77 frames before, timestamp in 90 kHz units
+	tag := id3Tag(3*77*1152*cHLSTimescale/44100, "")
+	if !bytes.HasPrefix(data, tag) || len(data) != len(tag)+77*417 {
+		t.Errorf("segment doesn't start with timestamp tag or has wrong size %d", len(data))
+	}
+
+	s.start("audio/mpeg", 2, 3)
+	writeFrames(&s, testMP3Frames(78))
+	playlist, _ = s.playlist()
+	if !strings.Contains(playlist, "#EXT-X-DISCONTINUITY\n#EXTINF:2.011,\n4.mp3") {
+		t.Errorf("restart is not marked as discontinuity:\n%s", playlist)
+	}
+}
+
+func TestFormatDetector(t *testing.T) {
+	var d formatDetector
+	d.reset(codecAAC)
+	// AAC LC, 44100 Hz, stereo, 371 bytes per 1024 samples
+	f, _ := parseADTSFrame([]byte{0xFF, 0xF1, 0x50, 0x80, 0x2E, 0x7F, 0xFC})
+	for i := 0; i < 100; i++ {
+		d.add(f)
+	}
+	if d.format != (audioFormat{Codec: codecAAC, SampleRate: 44100, Channels: 2, BitRate: 128}) {
+		t.Errorf("wrong format: %+v", d.format)
+	}
+}
+
+func TestHLSRestrictedMount(t *testing.T) {
+	srv := &Server{}
+	m := &mount{Name: "RockRadio96", SignSecret: "secret", server: srv, logger: nopLogger{}}
+	m.State.Started = true
+	srv.Options.Mounts = []*mount{m}
+
+	for _, file := range []string{cHLSPlaylist, "0.mp3"} {
+		w := httptest.NewRecorder()
+		srv.hlsHandler(w, httptest.NewRequest("GET", "/RockRadio96/"+file, nil))
+		if w.Code != http.StatusForbidden {
+			t.Errorf("%s of signed mount without exp and sig: got %d, want 403", file, w.Code)
+		}
+	}
+}
+
+func TestHLSClientsLimit(t *testing.T) {
+	srv := &Server{bans: &banList{}}
+	srv.Options.Limits.Clients = 1
+	m := &mount{Name: "RockRadio96", server: srv, logger: nopLogger{}}
+	m.State.Started = true
+	srv.Options.Mounts = []*mount{m}
+
+	for _, c := range []struct {
+		listeners int32
+		forbidden bool
+	}{{0, false}, {2, true}} {
+		srv.ListenersCount = c.listeners
+		w := httptest.NewRecorder()
+		srv.hlsHandler(w, httptest.NewRequest("GET", "/RockRadio96/"+cHLSPlaylist, nil))
+		if (w.Code == http.StatusForbidden) != c.forbidden {
+			t.Errorf("playlist with %d listeners on the server: got %d", c.listeners, w.Code)
+		}
+	}
+	if srv.connections["192.0.2.1"] != 0 {
+		t.Errorf("HLS connection is not released: %v", srv.connections)
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright 2019 Setin Sergei
+// Licensed under the Apache License, Version 2.0 (the "License")
+
+package ice
+
+import (
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// listener - client connected to the mount
+type listener struct {
+	ID        uint64
+	Addr      string
+	UserAgent string
+	Started   time.Time
+
+	conn    net.Conn
+	metaInt int
+
+	mux sync.Mutex
+	// mount to move listener to, set by admin
+	moveTo *mount
+}
+
+func (l *listener) setMoveTo(m *mount) {
+	l.mux.Lock()
+	l.moveTo = m
+	l.mux.Unlock()
+}
+
+// movedTo - returns mount, which listener has to be moved to, and resets it
+func (l *listener) movedTo() *mount {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	m := l.moveTo
+	l.moveTo = nil
+	return m
+}
+
+// kill - closes listener's connection, so its streaming loop breaks on the next write
+func (l *listener) kill() {
+	_ = l.conn.Close()
+}
+
+// newListener - creates listener of the request, its connection is set after hijacking
+func (m *mount) newListener(r *http.Request) *listener {
+	return &listener{
+		ID:        atomic.AddUint64(&m.server.lastClientID, 1),
+		Addr:      m.server.getHost(r.RemoteAddr),
+		UserAgent: r.UserAgent(),
+		Started:   time.Now(),
+		metaInt:   m.State.MetaInfo.MetaInt,
+	}
+}
+
+// addListener - registers listener in the mount and counts it
+func (m *mount) addListener(l *listener) {
+	m.trackListener(l)
+	m.incListeners()
+}
+
+// trackListener - registers listener, which is already counted by reserveListener
+func (m *mount) trackListener(l *listener) {
+	m.mux.Lock()
+	if m.listeners == nil {
+		m.listeners = make(map[uint64]*listener)
+	}
+	m.listeners[l.ID] = l
+	m.mux.Unlock()
+}
+
+// removeListener - unregisters listener from the mount
+func (m *mount) removeListener(l *listener) {
+	m.mux.Lock()
+	delete(m.listeners, l.ID)
+	m.mux.Unlock()
+	m.decListeners()
+}
+
+// moveListener - moves listener to another mount
+func (m *mount) moveListener(l *listener, dst *mount) {
+	m.removeListener(l)
+	dst.addListener(l)
+	m.logger.Info("Listener %d moved from %s to %s", l.ID, m.Name, dst.Name)
+}
+
+// checkMove - moves listener to the mount requested by admin, returns mount listener belongs to
+func (m *mount) checkMove(l *listener) *mount {
+	if dst := l.movedTo(); dst != nil && dst != m {
+		m.moveListener(l, dst)
+		return dst
+	}
+	return m
+}
+
+// getListener - returns listener by its id
+func (m *mount) getListener(id uint64) *listener {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	return m.listeners[id]
+}
+
+// getListeners - returns listeners of the mount ordered by id
+func (m *mount) getListeners() []*listener {
+	m.mux.Lock()
+	result := make([]*listener, 0, len(m.listeners))
+	for _, l := range m.listeners {
+		result = append(result, l)
+	}
+	m.mux.Unlock()
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+	return result
+}
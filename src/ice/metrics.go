@@ -0,0 +1,86 @@
+// Copyright 2019 Setin Sergei
+// Licensed under the Apache License, Version 2.0 (the "License")
+
+package ice
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// metricsWriter - writes metrics in prometheus text exposition format
+type metricsWriter struct {
+	w *bufio.Writer
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func (mw *metricsWriter) header(name, kind, help string) {
+	fmt.Fprintf(mw.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func (mw *metricsWriter) value(name string, value interface{}) {
+	fmt.Fprintf(mw.w, "%s %v\n", name, value)
+}
+
+func (mw *metricsWriter) mountValue(name, mountName string, value interface{}) {
+	fmt.Fprintf(mw.w, "%s{mount=\"%s\"} %v\n", name, labelEscaper.Replace(mountName), value)
+}
+
+// mountMetric - writes one metric family with a sample for every mount
+func (mw *metricsWriter) mountMetric(name, kind, help string, mounts []*mount, value func(m *mount) interface{}) {
+	mw.header(name, kind, help)
+	for _, m := range mounts {
+		mw.mountValue(name, m.Name, value(m))
+	}
+}
+
+func (i *Server) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	mw := &metricsWriter{w: bufio.NewWriter(w)}
+	defer mw.w.Flush()
+
+	mw.header("penguin_listeners", "gauge", "Number of listeners connected to the server.")
+	mw.value("penguin_listeners", atomic.LoadInt32(&i.ListenersCount))
+	mw.header("penguin_sources", "gauge", "Number of sources connected to the server.")
+	mw.value("penguin_sources", atomic.LoadInt32(&i.SourcesCount))
+
+	mounts := i.Mounts()
+	buffers := make(map[*mount]bufferInfo, len(mounts))
+	for _, m := range mounts {
+		buffers[m] = m.buffer.Info()
+	}
+
+	mw.mountMetric("penguin_mount_listeners", "gauge", "Number of listeners connected to the mount.", mounts,
+		func(m *mount) interface{} { return atomic.LoadInt32(&m.State.Listeners) })
+	mw.mountMetric("penguin_mount_source_connected", "gauge", "Whether a source is connected to the mount.", mounts,
+		func(m *mount) interface{} {
+			if m.isStarted() {
+				return 1
+			}
+			return 0
+		})
+	mw.mountMetric("penguin_mount_received_bytes_total", "counter", "Bytes received from sources of the mount.", mounts,
+		func(m *mount) interface{} { return atomic.LoadInt64(&m.bytesReceived) })
+	mw.mountMetric("penguin_mount_sent_bytes_total", "counter", "Bytes sent to listeners of the mount.", mounts,
+		func(m *mount) interface{} { return atomic.LoadInt64(&m.bytesSent) })
+	mw.mountMetric("penguin_mount_buffer_pages", "gauge", "Number of pages in the mount buffer.", mounts,
+		func(m *mount) interface{} { return buffers[m].Size })
+	mw.mountMetric("penguin_mount_buffer_pages_in_use", "gauge", "Number of mount buffer pages used by listeners.", mounts,
+		func(m *mount) interface{} { return buffers[m].InUse })
+	mw.mountMetric("penguin_mount_buffer_bytes", "gauge", "Size of the mount buffer in bytes.", mounts,
+		func(m *mount) interface{} { return buffers[m].SizeBytes })
+
+	if i.Options.Logging.UseStat {
+		i.mux.Lock()
+		cpuUsage, memUsage := i.cpuUsage, i.memUsage
+		i.mux.Unlock()
+		mw.header("penguin_process_cpu_usage_percent", "gauge", "CPU usage of the server process, percent.")
+		mw.value("penguin_process_cpu_usage_percent", cpuUsage)
+		mw.header("penguin_process_resident_memory_bytes", "gauge", "Resident memory size of the server process in bytes.")
+		mw.value("penguin_process_resident_memory_bytes", memUsage*1024)
+	}
+}
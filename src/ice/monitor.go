@@ -56,10 +56,11 @@ func (i *Server) sendMonitorInfo(interval int, client *websocket.Conn) {
 		}
 
 		monitorInfo := &monitorInfo{}
-		monitorInfo.Mounts = make([]mountInfo, 0, len(i.Options.Mounts))
+		mounts := i.Mounts()
+		monitorInfo.Mounts = make([]mountInfo, 0, len(mounts))
 
-		for idx := range i.Options.Mounts {
-			inf := i.Options.Mounts[idx].getMountsInfo()
+		for _, m := range mounts {
+			inf := m.getMountsInfo()
 			monitorInfo.Mounts = append(monitorInfo.Mounts, inf)
 		}
 		i.mux.Lock()
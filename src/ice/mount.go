@@ -5,7 +5,6 @@ package ice
 
 import (
 	"bufio"
-	"encoding/base64"
 	"errors"
 	"fmt"
 	"io"
@@ -14,6 +13,8 @@ import (
 	"math"
 	"net"
 	"net/http"
+	"net/http/httputil"
+	"net/url"
 	"os"
 	"regexp"
 	"strconv"
@@ -26,6 +27,12 @@ import (
 	"golang.org/x/text/transform"
 )
 
+const (
+	cMaxFallbackDepth = 8
+	// kbps, buffers are sized by bitrate, so bitrates above hi-res lossless streams are not accepted
+	cMaxBitRate = 10000
+)
+
 type metaData struct {
 	MetaInt      int
 	StreamTitle  string
@@ -37,10 +44,15 @@ type mountInfo struct {
 	Name      string
 	Listeners int32
 	UpTime    string
+	Relay     string
 	Buff      bufferInfo
 }
 
 type mount struct {
+	// traffic counters, accessed atomically and kept first for 64-bit alignment
+	bytesReceived int64
+	bytesSent     int64
+
 	Name         string `yaml:"Name"`
 	User         string `yaml:"User"`
 	Password     string `yaml:"Password"`
@@ -50,6 +62,33 @@ type mount struct {
 	BurstSize    int    `yaml:"BurstSize"`
 	DumpFile     string `yaml:"DumpFile"`
 	MaxListeners int    `yaml:"MaxListeners"`
+	// seconds of audio to send on start streaming, it's used instead of BurstSize if it's set
+	BurstDuration int `yaml:"BurstDuration"`
+	// htpasswd-like file with additional source credentials
+	CredentialsFile string `yaml:"CredentialsFile"`
+	// url auth backend, which is asked to admit sources instead of checking credentials
+	SourceAuthURL string `yaml:"SourceAuthURL"`
+	// url auth backend, which is asked to admit listeners and notified when they leave
+	ListenerAddURL    string `yaml:"ListenerAddURL"`
+	ListenerRemoveURL string `yaml:"ListenerRemoveURL"`
+	// secret of signed listener urls, SignBindIP requires urls to be signed for client's ip
+	SignSecret string `yaml:"SignSecret"`
+	SignBindIP bool   `yaml:"SignBindIP"`
+	// shoutcast v2 (ultravox) stream id of the mount
+	StreamID int `yaml:"StreamID"`
+	// upstream mount to pull the stream from instead of waiting for source
+	RelayURL      string `yaml:"RelayURL"`
+	RelayMetadata bool   `yaml:"RelayMetadata"`
+	// connect to upstream only while the mount has listeners, and keep connection during grace period after the last one
+	RelayOnDemand    bool `yaml:"RelayOnDemand"`
+	RelayGracePeriod int  `yaml:"RelayGracePeriod"`
+	// where to redirect listeners, when MaxListeners is reached
+	OverflowMount string `yaml:"OverflowMount"`
+	OverflowURL   string `yaml:"OverflowURL"`
+	// where to move listeners, when source disconnects
+	FallbackMount string `yaml:"FallbackMount"`
+
+	accessOptions `yaml:",inline"`
 
 	ContentType string
 	StreamURL   string
@@ -58,34 +97,82 @@ type mount struct {
 	logger Logger
 
 	State struct {
-		Started     bool
-		StartedTime time.Time
-		MetaInfo    metaData
-		Listeners   int32
+		Started      bool
+		StartedTime  time.Time
+		MetaInfo     metaData
+		Listeners    int32
+		ListenerPeak int32
+		// state of relay mount: idle, connecting or connected
+		Relay string
+		// format of the current source detected by its frames, codec is empty if it's unknown
+		Format audioFormat
+		// bitrate declared by the current source in its headers, 0 if it hasn't sent one.
+		// Buffers are sized by configured BitRate anyway
+		SourceBitRate int
 	}
 
 	mux      sync.Mutex
 	buffer   bufferQueue
+	hls      hlsStream
 	dumpFile *os.File
+	// parsers of the current source stream, used by receive only. Codec is detected by the probe
+	// of the stream's beginning
+	probe       []byte
+	probeOffset int64
+	probing     bool
+	frames      frameSplitter
+	ogg         oggStream
+	flac        flacStream
+	format      formatDetector
+	// Ogg header pages of the current source, they are sent to listeners before the stream
+	streamHeader []byte
+	// mount was removed from config and waits for its source to disconnect
+	retired bool
+	// current source has already appended data to the buffer
+	streaming  bool
+	sourceConn net.Conn
+	listeners  map[uint64]*listener
+	access     accessRules
+	// running relay and its options
+	relayStop     chan struct{}
+	relayURL      string
+	relayMetadata bool
+	// listeners waiting for on-demand relay and timer of its disconnection
+	relayDemand int
+	relayTimer  *time.Timer
+	// mount was created for the stream of master server
+	fromMaster bool
+	// mount was created by template for the source and is removed after it
+	dynamic bool
 }
 
 //Init ...
 func (m *mount) Init(srv *Server, logger Logger, poolManager PoolManager) error {
-	m.State.MetaInfo.MetaInt = m.BitRate * 1024 / 8 * 10
+	m.State.MetaInfo.MetaInt = bytesPerSecond(m.BitRate) * 10
 	m.server = srv
 	m.logger = logger
 	m.Clear()
 
+	var err error
+	m.access, err = newAccessRules(m.accessOptions)
+	if err != nil {
+		return err
+	}
+
 	if m.DumpFile > "" {
-		var err error
 		m.dumpFile, err = os.OpenFile(m.DumpFile, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666)
 		if err != nil {
 			return err
 		}
 	}
 
-	p := poolManager.Init(m.BitRate * 1024 / 8)
-	m.buffer.Init(m.BurstSize/(m.BitRate*1024/8)+2, p)
+	// page is appended every second
+	minSize := m.BurstSize/bytesPerSecond(m.BitRate) + 2
+	if m.BurstDuration+2 > minSize {
+		minSize = m.BurstDuration + 2
+	}
+	p := poolManager.Init(bytesPerSecond(m.BitRate))
+	m.buffer.Init(minSize, p)
 	return nil
 }
 
@@ -102,16 +189,129 @@ func (m *mount) Clear() {
 	defer m.mux.Unlock()
 	m.State.Started = false
 	m.State.StartedTime = time.Time{}
-	m.zeroListeners()
+	m.State.SourceBitRate = 0
+	m.streaming = false
+	m.sourceConn = nil
+	atomic.StoreInt32(&m.State.ListenerPeak, atomic.LoadInt32(&m.State.Listeners))
 	m.State.MetaInfo.StreamTitle = ""
 	m.StreamURL = fmt.Sprintf("http://%s:%d/%s", m.server.Options.Host, m.server.Options.Socket.Port, m.Name)
 }
 
+// update - applies reloaded config to the live mount
+func (m *mount) update(nm *mount) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.retired = false
+	m.fromMaster = nm.fromMaster
+	m.dynamic = nm.dynamic
+	m.User = nm.User
+	m.Password = nm.Password
+	m.CredentialsFile = nm.CredentialsFile
+	m.SourceAuthURL = nm.SourceAuthURL
+	m.ListenerAddURL = nm.ListenerAddURL
+	m.ListenerRemoveURL = nm.ListenerRemoveURL
+	m.SignSecret = nm.SignSecret
+	m.SignBindIP = nm.SignBindIP
+	m.StreamID = nm.StreamID
+	m.RelayURL = nm.RelayURL
+	m.RelayMetadata = nm.RelayMetadata
+	m.RelayOnDemand = nm.RelayOnDemand
+	m.RelayGracePeriod = nm.RelayGracePeriod
+	m.MaxListeners = nm.MaxListeners
+	m.OverflowMount = nm.OverflowMount
+	m.OverflowURL = nm.OverflowURL
+	m.FallbackMount = nm.FallbackMount
+	m.accessOptions = nm.accessOptions
+	if access, err := newAccessRules(nm.accessOptions); err == nil {
+		m.access = access
+	}
+	if !m.State.Started {
+		// otherwise they are taken from source headers
+		m.Description = nm.Description
+		m.Genre = nm.Genre
+	}
+	if m.BitRate != nm.BitRate || m.BurstSize != nm.BurstSize || m.BurstDuration != nm.BurstDuration || m.DumpFile != nm.DumpFile {
+		m.logger.Warning("Mount %s: changes of BitRate, BurstSize, BurstDuration and DumpFile require restart", m.Name)
+	}
+}
+
+// retire - marks mount as removed, returns true if it has no source and could be removed immediately
+func (m *mount) retire() bool {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.retired = true
+	return !m.State.Started
+}
+
+func (m *mount) isRetired() bool {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	return m.retired
+}
+
+func (m *mount) isFromMaster() bool {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	return m.fromMaster
+}
+
+func (m *mount) isDynamic() bool {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	return m.dynamic
+}
+
+func (m *mount) isStarted() bool {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	return m.State.Started
+}
+
+// isStreaming - returns true if source is connected and the buffer already has its data
+func (m *mount) isStreaming() bool {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	return m.State.Started && m.streaming
+}
+
+func (m *mount) setStreaming() {
+	m.mux.Lock()
+	m.streaming = true
+	m.mux.Unlock()
+}
+
 func (m *mount) incListeners() {
-	atomic.AddInt32(&m.State.Listeners, 1)
+	m.updatePeak(atomic.AddInt32(&m.State.Listeners, 1))
 	m.server.incListeners()
 }
 
+// reserveListener - counts listener, if mount's listeners limit isn't reached. Counter is checked and incremented
+// at once, so concurrent listeners couldn't exceed the limit
+func (m *mount) reserveListener() bool {
+	m.mux.Lock()
+	maxListeners := m.MaxListeners
+	m.mux.Unlock()
+	for {
+		listeners := atomic.LoadInt32(&m.State.Listeners)
+		if maxListeners > 0 && int(listeners) >= maxListeners {
+			return false
+		}
+		if atomic.CompareAndSwapInt32(&m.State.Listeners, listeners, listeners+1) {
+			m.server.incListeners()
+			return true
+		}
+	}
+}
+
+func (m *mount) updatePeak(listeners int32) {
+	for {
+		peak := atomic.LoadInt32(&m.State.ListenerPeak)
+		if listeners <= peak || atomic.CompareAndSwapInt32(&m.State.ListenerPeak, peak, listeners) {
+			break
+		}
+	}
+}
+
 func (m *mount) decListeners() {
 	if atomic.LoadInt32(&m.State.Listeners) > 0 {
 		atomic.AddInt32(&m.State.Listeners, -1)
@@ -119,43 +319,140 @@ func (m *mount) decListeners() {
 	}
 }
 
-func (m *mount) zeroListeners() {
-	atomic.StoreInt32(&m.State.Listeners, 0)
+// checkListeners - returns false if mount's listeners limit is reached
+func (m *mount) checkListeners() bool {
+	m.mux.Lock()
+	maxListeners := m.MaxListeners
+	m.mux.Unlock()
+	if maxListeners > 0 && int(atomic.LoadInt32(&m.State.Listeners)) >= maxListeners {
+		return false
+	}
+	return true
+}
+
+// overflowURL - returns url to redirect listeners to, when the mount is full
+func (m *mount) overflowURL(r *http.Request) string {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if m.OverflowMount > "" {
+		url := "http://" + r.Host + "/" + m.OverflowMount
+		if r.URL.RawQuery > "" {
+			url += "?" + r.URL.RawQuery
+		}
+		return url
+	}
+	return m.OverflowURL
 }
 
-func (m *mount) auth(w http.ResponseWriter, r *http.Request) error {
-	strAuth := r.Header.Get("authorization")
+// compatible - checks if listeners of the mount could be switched to another one
+func (m *mount) compatible(other *mount) bool {
+	m.mux.Lock()
+	contentType := m.ContentType
+	m.mux.Unlock()
+	other.mux.Lock()
+	otherContentType := other.ContentType
+	other.mux.Unlock()
+	return contentType == "" || otherContentType == "" || strings.EqualFold(contentType, otherContentType)
+}
 
-	if strAuth == "" {
-		m.saySourceHello(w)
-		return errors.New("no authorization field")
+// source - returns the mount listeners have to be fed from: mount itself if its source is connected,
+// otherwise the first mount with source in the fallback chain. Returns nil if there is no such mount
+func (m *mount) source() *mount {
+	t := m
+	// depth limit protects from cycles in the chain
+	for depth := 0; t != nil && depth < cMaxFallbackDepth; depth++ {
+		if t.isStreaming() && m.compatible(t) {
+			return t
+		}
+		t.mux.Lock()
+		fallback := t.FallbackMount
+		t.mux.Unlock()
+		if fallback == "" {
+			break
+		}
+		t = m.server.getMount(fallback)
 	}
+	return nil
+}
 
-	s := strings.SplitN(strAuth, " ", 2)
-	if len(s) != 2 {
-		http.Error(w, "Not authorized", http.StatusUnauthorized)
-		return errors.New("not authorized")
+// nextPage - returns the page to send after pack, the mount it belongs to and offset to send the page from.
+// Switches listener to the fallback mount and back, when sources disconnect or return
+func (m *mount) nextPage(cur *mount, pack *bufElement) (*mount, *bufElement, int) {
+	if src := m.source(); src != nil && src != cur {
+		if last := src.buffer.Last(); last != nil {
+			m.logger.Info("Listener of %s switched from %s to %s", m.Name, cur.Name, src.Name)
+			return src, last, src.buffer.FrameOffset(last)
+		}
 	}
+	return cur, pack.Next(), 0
+}
 
-	b, err := base64.StdEncoding.DecodeString(s[1])
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusUnauthorized)
+// killSource - disconnects source of the mount, returns false if there is no source
+func (m *mount) killSource() bool {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if m.sourceConn == nil {
+		return false
+	}
+	_ = m.sourceConn.Close()
+	return true
+}
+
+// moveListeners - moves all listeners of the mount to dst
+func (m *mount) moveListeners(dst *mount) {
+	for _, l := range m.getListeners() {
+		l.setMoveTo(dst)
+	}
+}
+
+// checkClient - checks access lists and connections limit of the address, answers 403 if client is not allowed.
+// If client is allowed, its connection is counted and has to be released by caller
+func (m *mount) checkClient(w http.ResponseWriter, host string, isSource bool) bool {
+	if err := m.admitClient(host, isSource); err != nil {
+		m.logger.Error("Client of %s from %s: %s", m.Name, host, err.Error())
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// admitClient - checks access lists and connections limit of the address, counts connection if client is allowed
+func (m *mount) admitClient(host string, isSource bool) error {
+	if err := m.server.checkAccess(m, host, isSource); err != nil {
 		return err
 	}
+	if !m.server.acquireConnection(host) {
+		return errors.New("number of connections per ip exceeded")
+	}
+	return nil
+}
 
-	pair := strings.SplitN(string(b), ":", 2)
-	if len(pair) != 2 {
+// auth - checks source credentials, answers 401 if they are wrong
+func (m *mount) auth(w http.ResponseWriter, r *http.Request) error {
+	user, password, ok := r.BasicAuth()
+	if !ok {
+		w.Header().Set("WWW-Authenticate", "Basic realm=\""+cAdminRealm+"\"")
 		http.Error(w, "Not authorized", http.StatusUnauthorized)
-		return errors.New("not authorized")
+		return errors.New("no authorization field")
+	}
+
+	m.mux.Lock()
+	authURL := m.SourceAuthURL
+	m.mux.Unlock()
+	if authURL > "" {
+		if err := m.sourceAuth(authURL, r); err != nil {
+			http.Error(w, "Not authorized", http.StatusUnauthorized)
+			return err
+		}
+		return nil
 	}
 
-	if m.Password != pair[1] && m.User != pair[0] {
+	if !m.checkCredentials(user, password) {
+		w.Header().Set("WWW-Authenticate", "Basic realm=\""+cAdminRealm+"\"")
 		http.Error(w, "Not authorized", http.StatusUnauthorized)
 		return errors.New("wrong user or password")
 	}
 
-	m.saySourceHello(w)
-
 	return nil
 }
 
@@ -182,7 +479,7 @@ func (m *mount) sayHello(w *bufio.ReadWriter, icyMeta bool) {
 	_, _ = w.WriteString(m.ContentType)
 	_, _ = w.WriteString("\r\nConnection: Keep-Alive\r\n")
 	_, _ = w.WriteString("X-Audiocast-Bitrate: ")
-	_, _ = w.WriteString(strconv.Itoa(m.BitRate))
+	_, _ = w.WriteString(strconv.Itoa(m.declaredBitRate()))
 	_, _ = w.WriteString("\r\nX-Audiocast-Name: ")
 	_, _ = w.WriteString(m.Name)
 	_, _ = w.WriteString("\r\nX-Audiocast-Genre: ")
@@ -193,6 +490,11 @@ func (m *mount) sayHello(w *bufio.ReadWriter, icyMeta bool) {
 	_, _ = w.WriteString("X-Audiocast-Description: ")
 	_, _ = w.WriteString(m.Description)
 	_, _ = w.WriteString("\r\n")
+	if audioInfo := m.audioInfo(); audioInfo > "" {
+		_, _ = w.WriteString("ice-audio-info: ")
+		_, _ = w.WriteString(audioInfo)
+		_, _ = w.WriteString("\r\n")
+	}
 	if icyMeta {
 		_, _ = w.WriteString("Icy-Metaint: ")
 		_, _ = w.WriteString(strconv.Itoa(m.State.MetaInfo.MetaInt))
@@ -202,23 +504,60 @@ func (m *mount) sayHello(w *bufio.ReadWriter, icyMeta bool) {
 	w.Flush()
 }
 
-func (m *mount) saySourceHello(w http.ResponseWriter) {
-	w.Header().Set("Server", m.server.serverName+"/"+m.server.version)
-	w.Header().Set("Connection", "Keep-Alive")
-	w.Header().Set("Allow", "GET, SOURCE")
-	w.Header().Set("Cache-Control", "no-cache")
-	w.Header().Set("Pragma", "no-cache")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Transfer-Encoding", "chunked")
-	w.WriteHeader(http.StatusOK)
+// saySourceHello - answers source the way icecast does: 100 Continue if source waits for it before
+// sending the stream (PUT with Expect: 100-continue), otherwise 200 OK
+func (m *mount) saySourceHello(w *bufio.Writer, r *http.Request) error {
+	if strings.EqualFold(r.Header.Get("Expect"), "100-continue") {
+		_, _ = w.WriteString("HTTP/1.1 100 Continue\r\n\r\n")
+	} else {
+		_, _ = w.WriteString("HTTP/1.0 200 OK\r\n")
+		_, _ = w.WriteString("Server: ")
+		_, _ = w.WriteString(m.server.serverName)
+		_, _ = w.WriteString("/")
+		_, _ = w.WriteString(m.server.version)
+		_, _ = w.WriteString("\r\nAllow: GET, SOURCE, PUT\r\n")
+		_, _ = w.WriteString("Cache-Control: no-cache\r\n")
+		_, _ = w.WriteString("Pragma: no-cache\r\n\r\n")
+	}
+	return w.Flush()
+}
 
-	flusher, _ := w.(http.Flusher)
-	flusher.Flush()
+// sourceBody - returns reader of the stream sent by source after headers
+func sourceBody(r *http.Request, reader *bufio.Reader) io.Reader {
+	if len(r.TransferEncoding) > 0 && r.TransferEncoding[0] == "chunked" {
+		return httputil.NewChunkedReader(reader)
+	}
+	if r.ContentLength > 0 {
+		return io.LimitReader(reader, r.ContentLength)
+	}
+	return reader
 }
 
 func (m *mount) writeICEHeaders(r *http.Request) {
-	var bitRateStr string
-	bitRateStr = r.Header.Get("ice-bitrate")
+	m.State.SourceBitRate = m.sourceBitRate(r)
+	m.Genre = iceHeader(r, "genre", "genre")
+	m.ContentType = r.Header.Get("content-type")
+	m.Description = iceHeader(r, "description", "description")
+}
+
+// declaredBitRate - returns bitrate declared by the current source, configured one if it hasn't sent it
+func (m *mount) declaredBitRate() int {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if m.State.SourceBitRate > 0 {
+		return m.State.SourceBitRate
+	}
+	return m.BitRate
+}
+
+// bytesPerSecond - returns size of a second of the stream with bitrate in kbps
+func bytesPerSecond(bitRate int) int {
+	return bitRate * 1024 / 8
+}
+
+// sourceBitRate - returns bitrate sent by source in its headers, 0 if there is no one or it is out of range
+func (m *mount) sourceBitRate(r *http.Request) int {
+	bitRateStr := iceHeader(r, "bitrate", "br")
 	if bitRateStr == "" {
 		audioInfo := r.Header.Get("ice-audio-info")
 		if len(audioInfo) > 3 {
@@ -226,24 +565,27 @@ func (m *mount) writeICEHeaders(r *http.Request) {
 			bitRateStr = params["bitrate"]
 		}
 	}
-
 	bRate, err := strconv.Atoi(bitRateStr)
-	if err == nil {
-		m.BitRate = bRate
+	if err != nil || bRate < 0 || bRate > cMaxBitRate {
+		return 0
 	}
+	return bRate
+}
 
-	m.Genre = r.Header.Get("ice-genre")
-	m.ContentType = r.Header.Get("content-type")
-	m.Description = r.Header.Get("ice-description")
+// iceHeader - returns ice-* header of the source, or icy-* one sent by shoutcast encoders
+func iceHeader(r *http.Request, ice, icy string) string {
+	if value := r.Header.Get("ice-" + ice); value > "" {
+		return value
+	}
+	return r.Header.Get("icy-" + icy)
 }
 
 func (m *mount) meta(w http.ResponseWriter, r *http.Request) {
 	if m.auth(w, r) != nil {
 		return
 	}
+	m.server.writeIceResponse(w, "Metadata update successful", true)
 
-	var metaSize byte
-	var mStr string
 	song := r.URL.Query().Get("song")
 	songReader := strings.NewReader(song)
 	enc, _, _ := charset.DetermineEncoding(([]byte)(song), "")
@@ -257,8 +599,16 @@ func (m *mount) meta(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	m.setMetaTitle(string(result[:]))
+}
+
+// setMetaTitle - sets stream title and prepares icy metadata block for listeners
+func (m *mount) setMetaTitle(title string) {
+	var metaSize byte
+	var mStr string
+
 	m.mux.Lock()
-	m.State.MetaInfo.StreamTitle = string(result[:])
+	m.State.MetaInfo.StreamTitle = title
 
 	if m.State.MetaInfo.StreamTitle > "" {
 		mStr = "StreamTitle='" + m.State.MetaInfo.StreamTitle + "';"
@@ -275,6 +625,7 @@ func (m *mount) meta(w http.ResponseWriter, r *http.Request) {
 		m.State.MetaInfo.meta[idx+1] = mStr[idx]
 	}
 	m.mux.Unlock()
+	m.hls.setTitle(title)
 }
 
 func fmtDuration(d time.Duration) string {
@@ -292,6 +643,7 @@ func (m *mount) getMountsInfo() mountInfo {
 	t.Listeners = atomic.LoadInt32(&m.State.Listeners)
 	m.mux.Lock()
 	t.Name = m.Name
+	t.Relay = m.State.Relay
 	if m.State.Started {
 		t.UpTime = fmtDuration(time.Since(m.State.StartedTime))
 		t.Buff = m.buffer.Info()
@@ -300,6 +652,45 @@ func (m *mount) getMountsInfo() mountInfo {
 	return t
 }
 
+func (m *mount) getSourceStats() sourceStats {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	stats := sourceStats{
+		Mount:             "/" + m.Name,
+		Bitrate:           m.BitRate,
+		Genre:             m.Genre,
+		ListenerPeak:      atomic.LoadInt32(&m.State.ListenerPeak),
+		Listeners:         atomic.LoadInt32(&m.State.Listeners),
+		ListenURL:         m.StreamURL,
+		MaxListeners:      "unlimited",
+		ServerDescription: m.Description,
+		ServerName:        m.Name,
+		ServerType:        m.ContentType,
+		Title:             m.State.MetaInfo.StreamTitle,
+		TotalBytesRead:    atomic.LoadInt64(&m.bytesReceived),
+		TotalBytesSent:    atomic.LoadInt64(&m.bytesSent),
+	}
+	if m.MaxListeners > 0 {
+		stats.MaxListeners = strconv.Itoa(m.MaxListeners)
+	}
+	if m.State.Started {
+		stats.StreamStart = m.State.StartedTime.Format(time.RFC1123Z)
+		stats.StreamStartISO8601 = m.State.StartedTime.Format(cISO8601)
+	}
+	if m.State.SourceBitRate > 0 {
+		stats.Bitrate = m.State.SourceBitRate
+	}
+	stats.AudioInfo = "bitrate=" + strconv.Itoa(stats.Bitrate)
+	if format := m.State.Format; format.Codec > "" {
+		stats.DetectedBitrate = format.BitRate
+		stats.Channels = format.Channels
+		stats.SampleRate = format.SampleRate
+		stats.AudioCodec = format.Codec
+		stats.AudioInfo = fmt.Sprintf("bitrate=%d;channels=%d;samplerate=%d", format.BitRate, format.Channels, format.SampleRate)
+	}
+	return stats
+}
+
 // icy style metadata
 func (m *mount) getIcyMeta() ([]byte, int) {
 	m.mux.Lock()
@@ -312,58 +703,104 @@ func (m *mount) getIcyMeta() ([]byte, int) {
 	Authenticate SOURCE and write stream from it to appropriate mount buffer
 */
 func (m *mount) write(w http.ResponseWriter, r *http.Request) {
+	host, ok := m.admitSource(w, r)
+	if !ok {
+		return
+	}
+	defer m.server.releaseConnection(host)
+	m.stream(w, r)
+}
+
+// admitSource - checks sources limit, access and credentials of the source, answers with error if it's not allowed.
+// If source is allowed, its connection is counted and has to be released by caller
+func (m *mount) admitSource(w http.ResponseWriter, r *http.Request) (string, bool) {
 	if !m.server.checkSources() {
 		m.logger.Error("Number of sources exceeded")
 		http.Error(w, "Number of sources exceeded", 403)
+		return "", false
+	}
+
+	host := m.server.getHost(r.RemoteAddr)
+	if !m.checkClient(w, host, true) {
+		return "", false
+	}
+
+	if err := m.auth(w, r); err != nil {
+		m.logger.Error("Source of %s: %s", m.Name, err.Error())
+		m.server.releaseConnection(host)
+		return "", false
+	}
+	return host, true
+}
+
+// stream - receives stream of admitted source
+func (m *mount) stream(w http.ResponseWriter, r *http.Request) {
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		m.logger.Error("webServer doesn't support hijacking")
+		http.Error(w, "webServer doesn't support hijacking", http.StatusInternalServerError)
 		return
 	}
 
-	if !m.State.Started {
-		m.mux.Lock()
-		err := m.auth(w, r)
-		if err != nil {
-			m.mux.Unlock()
-			m.logger.Error(err.Error())
-			return
-		}
-		m.writeICEHeaders(r)
-		m.State.Started = true
-		m.State.StartedTime = time.Now()
-		m.mux.Unlock()
-	} else {
+	if !m.startSource(r) {
 		m.logger.Error("SOURCE already connected")
 		http.Error(w, "SOURCE already connected", 403)
 		return
 	}
 
 	bytesSent := 0
-	idle := 0
-	read := 0
-	var err error
 	start := time.Now()
 
 	m.logger.Info("writeMount %s", m.Name)
-	defer m.close(true, &bytesSent, start, r)
+	defer m.close(nil, &bytesSent, start, r)
 
-	hj, ok := w.(http.Hijacker)
-	if !ok {
-		m.logger.Error("webServer doesn't support hijacking")
-		http.Error(w, "webServer doesn't support hijacking", http.StatusInternalServerError)
-		return
-	}
-
-	conn, _, err := hj.Hijack()
+	// net/http would answer 100 Continue only on body reading, so source is answered through the raw connection.
+	// Hijacked reader could already contain the beginning of the stream
+	conn, bufRW, err := hj.Hijack()
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 	defer conn.Close()
 
-	bufRW := bufio.NewReaderSize(conn, 1024*m.BitRate/8)
+	if err = m.saySourceHello(bufRW.Writer, r); err != nil {
+		m.logger.Error(err.Error())
+		return
+	}
+
+	bytesSent = m.receive(conn, sourceBody(r, bufRW.Reader))
+}
+
+// startSource - marks mount as started by the source, returns false if another source is already connected or mount is retired
+func (m *mount) startSource(r *http.Request) bool {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	// retired mount could be removed at any moment
+	if m.State.Started || m.retired {
+		return false
+	}
+	m.writeICEHeaders(r)
+	m.State.Started = true
+	m.State.StartedTime = time.Now()
+	return true
+}
+
+// receive - reads stream of the source connection from reader to the mount buffer, returns number of bytes read
+func (m *mount) receive(conn net.Conn, reader io.Reader) int {
+	bytesSent := 0
+	idle := 0
+	read := 0
+	var err error
+
+	m.mux.Lock()
+	m.sourceConn = conn
+	m.mux.Unlock()
 
 	m.server.incSources()
+	m.startParsing()
+
 	// max bytes per second according to bitrate
-	buff := make([]byte, m.BitRate*1024/8)
+	buff := make([]byte, bytesPerSecond(m.BitRate))
 
 	for {
 		//check, if server has to be stopped
@@ -371,14 +808,18 @@ func (m *mount) write(w http.ResponseWriter, r *http.Request) {
 			break
 		}
 
-		read, err = bufRW.Read(buff)
-		if err != nil {
-			if err == io.EOF {
-				idle++
-				if idle >= m.server.Options.Limits.SourceIdleTimeOut {
-					m.logger.Error("Source idle time is reached")
-					break
-				}
+		read, err = reader.Read(buff)
+		if err != nil && err != io.EOF {
+			// connection is broken or closed by admin
+			m.logger.Error(err.Error())
+			break
+		}
+		// end of the body could be read along with its last bytes
+		if err == io.EOF && read == 0 {
+			idle++
+			if sourceIdleTimeOut, _, _ := m.server.timeOuts(); idle >= sourceIdleTimeOut {
+				m.logger.Error("Source idle time is reached")
+				break
 			}
 			m.logger.Error(err.Error())
 		} else {
@@ -386,7 +827,17 @@ func (m *mount) write(w http.ResponseWriter, r *http.Request) {
 		}
 		// append to the buffer's queue based on actual read bytes
 		m.buffer.Append(buff, read)
+		m.splitFrames(buff[:read])
+		// read a second of the stream at once, even if source sends more than its bitrate
+		if size := bytesPerSecond(m.format.format.BitRate); size > len(buff) {
+			m.logger.Warning("Mount %s: bitrate of the stream is %d kbps", m.Name, m.format.format.BitRate)
+			buff = make([]byte, size)
+		}
+		if bytesSent == 0 && read > 0 {
+			m.setStreaming()
+		}
 		bytesSent += read
+		atomic.AddInt64(&m.bytesReceived, int64(read))
 		m.logger.Debug("writeMount %d", read)
 
 		if m.dumpFile != nil {
@@ -398,6 +849,34 @@ func (m *mount) write(w http.ResponseWriter, r *http.Request) {
 		//check if max buffer size reached and truncate it
 		m.buffer.checkAndTruncate()
 	}
+	return bytesSent
+}
+
+// receiveStream - writes stream of the source, which is not connected through http server, to the mount.
+// Returns false if another source is already connected
+func (m *mount) receiveStream(conn net.Conn, header http.Header, proto string, reader io.Reader) bool {
+	// request-like description of the source for headers parsing and access log
+	r := &http.Request{
+		Method:     "SOURCE",
+		URL:        &url.URL{Path: "/" + m.Name},
+		RequestURI: "/" + m.Name,
+		Proto:      proto,
+		Header:     header,
+		RemoteAddr: conn.RemoteAddr().String(),
+	}
+	if !m.startSource(r) {
+		m.logger.Error("SOURCE already connected")
+		return false
+	}
+
+	bytesSent := 0
+	start := time.Now()
+
+	m.logger.Info("writeMount %s from %s source", m.Name, proto)
+	defer m.close(nil, &bytesSent, start, r)
+
+	bytesSent = m.receive(conn, reader)
+	return true
 }
 
 /*
@@ -411,20 +890,55 @@ func (m *mount) read(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Number of listeners exceeded", 403)
 		return
 	}
+	host := m.server.getHost(r.RemoteAddr)
+	if !m.checkClient(w, host, false) {
+		return
+	}
+	defer m.server.releaseConnection(host)
+	if !m.reserveListener() {
+		if url := m.overflowURL(r); url > "" {
+			m.logger.Info("Mount %s is full, redirecting listener to %s", m.Name, url)
+			http.Redirect(w, r, url, http.StatusFound)
+			return
+		}
+		m.logger.Error("Number of listeners of mount %s exceeded", m.Name)
+		http.Error(w, "Number of listeners exceeded", 403)
+		return
+	}
+	// reserved slot is released, unless listener is added to the mount
+	reserved := true
+	defer func() {
+		if reserved {
+			m.decListeners()
+		}
+	}()
 	if r.Header.Get("icy-metadata") == "1" {
 		icyMeta = true
 	}
 
+	l := m.newListener(r)
+	if err := m.checkSignature(r, l.Addr); err != nil {
+		m.logger.Error("Listener of %s from %s: %s", m.Name, l.Addr, err.Error())
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if err := m.listenerAdd(l, r); err != nil {
+		m.logger.Error("Listener of %s from %s: %s", m.Name, l.Addr, err.Error())
+		http.Error(w, "Not authorized", http.StatusForbidden)
+		return
+	}
+
 	var meta []byte
 	var err error
 	var beginIteration time.Time
 	var pack, nextPack *bufElement
+	var cur *mount
 
 	bytesSent := 0
 	write := 0
 	idle := 0
-	idleTimeOut := m.server.Options.Limits.EmptyBufferIdleTimeOut * 1000
-	writeTimeOut := time.Second * time.Duration(m.server.Options.Limits.WriteTimeOut)
+	_, idleTimeOut, writeTimeOut := m.server.timeOuts()
+	idleTimeOut *= 1000
 	offset := 0
 	noMetaBytes := 0
 	partWrite := 0
@@ -446,22 +960,45 @@ func (m *mount) read(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	defer conn.Close()
+	l.conn = conn
 
 	start := time.Now()
 
 	m.logger.Debug("readMount %s", m.Name)
-	defer m.close(false, &bytesSent, start, r)
+	defer m.close(l, &bytesSent, start, r)
+
+	if m.demandRelay() {
+		defer m.releaseRelay()
+	}
+
+	// mount to read stream from, could be one of the fallbacks
+	cur = m.source()
+	if cur == nil {
+		cur = m
+	}
 
 	//try to maximize unused buffer pages from beginning
-	pack = m.buffer.Start(m.BurstSize)
+	burstSize, burstDuration := m.burst(r)
+	pack, burst := cur.buffer.Start(burstSize, burstDuration)
 
 	if pack == nil {
 		m.logger.Error("readMount Empty buffer")
 		return
 	}
+	// start sending from the beginning of a frame, so decoders don't have to look for it
+	skip := cur.buffer.FrameOffset(pack)
+	header := cur.getStreamHeader()
 
 	m.sayHello(bufRW, icyMeta)
-	m.incListeners()
+
+	// mount, listener belongs to. Could be changed by admin
+	owner := m
+	m.trackListener(l)
+	reserved = false
+	m.updatePeak(atomic.LoadInt32(&m.State.Listeners))
+	defer func() {
+		owner.removeListener(l)
+	}()
 
 OuterLoop:
 	for {
@@ -474,22 +1011,28 @@ OuterLoop:
 
 		n++
 		pack.Lock()
+		page := pack.buffer[skip:]
+		if len(header) > 0 {
+			// header is sent with the page, so icy metadata is counted right
+			page = append(header, page...)
+			header = nil
+		}
 		if icyMeta {
-			meta, metaLen = m.getIcyMeta()
+			meta, metaLen = cur.getIcyMeta()
 
-			if noMetaBytes+pack.len+delta > m.State.MetaInfo.MetaInt {
-				offset = m.State.MetaInfo.MetaInt - noMetaBytes - delta
+			if noMetaBytes+len(page)+delta > l.metaInt {
+				offset = l.metaInt - noMetaBytes - delta
 
 				//log.Printf("*** write block with meta ***")
 				//log.Printf("   offset = %d - %d(nometabytes) - %d (delta) = %d", mount.State.MetaInfo.MetaInt, nometabytes, delta, offset)
 
-				if offset < 0 || offset >= pack.len {
+				if offset < 0 || offset >= len(page) {
 					m.logger.Warning("Bad meta-info offset %d", offset)
 					log.Printf("!!! Bad metainfo offset %d ***", offset)
 					offset = 0
 				}
 
-				partWrite, err = bufRW.Write(pack.buffer[:offset])
+				partWrite, err = bufRW.Write(page[:offset])
 				if err != nil {
 					m.closeAndUnlock(pack, err)
 					break
@@ -501,7 +1044,7 @@ OuterLoop:
 					break
 				}
 				write += partWrite
-				partWrite, err = bufRW.Write(pack.buffer[offset:])
+				partWrite, err = bufRW.Write(page[offset:])
 				if err != nil {
 					m.closeAndUnlock(pack, err)
 					break
@@ -515,11 +1058,11 @@ OuterLoop:
 				//log.Printf("   delta = %d(writed) - %d(offset) - %d(metalen) = %d", writed, offset, metalen, delta)
 			} else {
 				write = 0
-				noMetaTmp, err = bufRW.Write(pack.buffer)
+				noMetaTmp, err = bufRW.Write(page)
 				noMetaBytes += noMetaTmp
 			}
 		} else {
-			write, err = bufRW.Write(pack.buffer)
+			write, err = bufRW.Write(page)
 		}
 
 		if err != nil {
@@ -528,15 +1071,18 @@ OuterLoop:
 		}
 
 		bytesSent += write + noMetaTmp
+		atomic.AddInt64(&m.bytesSent, int64(write+noMetaTmp))
 
 		// send burst data without waiting
-		if bytesSent >= m.BurstSize {
+		if bytesSent >= burst {
 			if time.Since(beginIteration) < time.Second {
 				time.Sleep(time.Second - time.Since(beginIteration))
 			}
 		}
 
-		nextPack = pack.Next()
+		owner = owner.checkMove(l)
+		prev := cur
+		cur, nextPack, skip = owner.nextPage(cur, pack)
 		for nextPack == nil {
 			time.Sleep(time.Millisecond * 250)
 			idle += 250
@@ -544,15 +1090,32 @@ OuterLoop:
 				m.closeAndUnlock(pack, errors.New("empty Buffer idle time is reached"))
 				break OuterLoop
 			}
-			nextPack = pack.Next()
+			owner = owner.checkMove(l)
+			cur, nextPack, skip = owner.nextPage(cur, pack)
 		}
 		idle = 0
 		pack.UnLock()
+		if cur != prev {
+			header = cur.getStreamHeader()
+		}
 
 		pack = nextPack
 	}
 }
 
+// burst - returns size and duration of burst for the listener, which could be overridden by burst parameter
+// of the request in seconds. Duration is used, if durations of stream's pages are known, otherwise
+// it's converted to size by bitrate
+func (m *mount) burst(r *http.Request) (int, time.Duration) {
+	seconds := float64(m.BurstDuration)
+	if burst, err := strconv.ParseFloat(r.URL.Query().Get("burst"), 64); err == nil && burst >= 0 {
+		seconds = burst
+	} else if m.BurstDuration == 0 {
+		return m.BurstSize, 0
+	}
+	return int(seconds * float64(bytesPerSecond(m.BitRate))), time.Duration(seconds * float64(time.Second))
+}
+
 func (m *mount) closeAndUnlock(pack *bufElement, err error) {
 	if te, ok := err.(net.Error); ok && te.Timeout() {
 		log.Println("Write timeout " + te.Error())
@@ -563,12 +1126,16 @@ func (m *mount) closeAndUnlock(pack *bufElement, err error) {
 	pack.UnLock()
 }
 
-func (m *mount) close(isSource bool, bytesSend *int, start time.Time, r *http.Request) {
-	if isSource {
+// close - finishes source's (l is nil) or listener's session
+func (m *mount) close(l *listener, bytesSend *int, start time.Time, r *http.Request) {
+	if l == nil {
 		m.server.decSources()
 		m.Clear()
+		if m.isRetired() {
+			m.server.removeMount(m)
+		}
 	} else {
-		m.decListeners()
+		m.listenerRemove(l, r)
 	}
 	t := time.Now()
 	elapsed := t.Sub(start)
@@ -0,0 +1,175 @@
+// Copyright 2019 Setin Sergei
+// Licensed under the Apache License, Version 2.0 (the "License")
+
+package ice
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+const (
+	// fixed part of Ogg page header, segment table follows it
+	cOggHeaderSize = 27
+	cOggFlagBOS    = 0x02
+	// granule position of pages, where no packet ends
+	cOggNoGranule = -1
+)
+
+var oggCapture = []byte("OggS")
+
+// oggPage - parsed header of Ogg page
+type oggPage struct {
+	// length of the page including header
+	size    int
+	bos     bool
+	granule int64
+	serial  uint32
+	// page carries codec headers of the logical stream
+	header bool
+	// samples, which the page completes, sample rate is 0 if it's unknown
+	audio audioFrame
+}
+
+// parseOggPage - parses Ogg page header at the beginning of b, b has to contain whole segment table
+func parseOggPage(b []byte) (oggPage, bool) {
+	var p oggPage
+	if len(b) < cOggHeaderSize || !bytes.HasPrefix(b, oggCapture) || b[4] != 0 {
+		return p, false
+	}
+	segments := int(b[26])
+	if len(b) < cOggHeaderSize+segments {
+		return p, false
+	}
+	p.size = cOggHeaderSize + segments
+	for _, lacing := range b[cOggHeaderSize : cOggHeaderSize+segments] {
+		p.size += int(lacing)
+	}
+	p.bos = b[5]&cOggFlagBOS != 0
+	p.granule = int64(binary.LittleEndian.Uint64(b[6:14]))
+	p.serial = binary.LittleEndian.Uint32(b[14:18])
+	return p, true
+}
+
+// oggStreamInfo - returns sample rate and channels declared by the first packet of logical stream,
+// 0 if codec is unknown
+func oggStreamInfo(packet []byte) (int, int) {
+	switch {
+	case bytes.HasPrefix(packet, []byte("OpusHead")) && len(packet) >= 10:
+		// granule position of Opus is always counted at 48 kHz
+		return 48000, int(packet[9])
+	case bytes.HasPrefix(packet, []byte("\x01vorbis")) && len(packet) >= 16:
+		return int(binary.LittleEndian.Uint32(packet[12:16])), int(packet[11])
+	}
+	return 0, 0
+}
+
+// oggLogical - state of logical bitstream
+type oggLogical struct {
+	sampleRate int
+	channels   int
+	granule    int64
+}
+
+// oggStream - splits Ogg stream into pages and keeps header pages of the current logical streams,
+// which have to be sent to listeners before any audio page. Header pages are the BOS pages and following
+// ones up to the first page with audio granule position. BOS page after audio means the next chained stream
+type oggStream struct {
+	active  bool
+	pending []byte
+	// position of pending data in the stream
+	offset int64
+
+	headers     []byte
+	headersDone bool
+	streams     map[uint32]*oggLogical
+}
+
+func (s *oggStream) reset(active bool) {
+	s.active = active
+	s.pending = s.pending[:0]
+	s.headers = nil
+	s.headersDone = false
+	s.streams = make(map[uint32]*oggLogical)
+}
+
+func (s *oggStream) write(data []byte) {
+	s.pending = append(s.pending, data...)
+}
+
+// next - returns the next complete page, its header and position in the stream, false if more data is needed.
+// Page stays valid until the next write
+func (s *oggStream) next() ([]byte, oggPage, int64, bool) {
+	for len(s.pending) >= cOggHeaderSize {
+		if !bytes.HasPrefix(s.pending, oggCapture) || s.pending[4] != 0 {
+			// look for the next capture pattern, its beginning could be at the end of data
+			idx := bytes.Index(s.pending[1:], oggCapture)
+			if idx == -1 {
+				s.skip(len(s.pending) - len(oggCapture) + 1)
+				break
+			}
+			s.skip(idx + 1)
+			continue
+		}
+		if len(s.pending) < cOggHeaderSize+int(s.pending[26]) {
+			break
+		}
+		p, _ := parseOggPage(s.pending)
+		if len(s.pending) < p.size {
+			break
+		}
+		page := s.pending[:p.size]
+		pos := s.offset
+		s.skip(p.size)
+		s.account(&p, page)
+		return page, p, pos, true
+	}
+	return nil, oggPage{}, 0, false
+}
+
+func (s *oggStream) skip(n int) {
+	s.pending = s.pending[n:]
+	s.offset += int64(n)
+}
+
+// account - updates headers and logical streams by the page, sets its header flag and duration
+func (s *oggStream) account(p *oggPage, page []byte) {
+	if p.bos {
+		if s.headersDone {
+			// chained stream begins
+			s.headers = nil
+			s.headersDone = false
+			s.streams = make(map[uint32]*oggLogical)
+		}
+		st := &oggLogical{}
+		st.sampleRate, st.channels = oggStreamInfo(page[cOggHeaderSize+int(page[26]):])
+		s.streams[p.serial] = st
+	}
+	st := s.streams[p.serial]
+	if !s.headersDone {
+		if st != nil && (p.granule == 0 || p.granule == cOggNoGranule) {
+			p.header = true
+			s.headers = append(s.headers, page...)
+			return
+		}
+		s.headersDone = true
+	}
+	if st == nil || p.granule == cOggNoGranule {
+		return
+	}
+	if st.sampleRate > 0 && p.granule > st.granule {
+		p.audio = audioFrame{
+			size:       len(page),
+			samples:    int(p.granule - st.granule),
+			sampleRate: st.sampleRate,
+			channels:   st.channels,
+		}
+	}
+	st.granule = p.granule
+}
+
+// header - returns header pages of the current logical streams
+func (s *oggStream) header() []byte {
+	// listeners append the burst to it, so it mustn't share capacity with pages to come
+	return s.headers[:len(s.headers):len(s.headers)]
+}
@@ -0,0 +1,84 @@
+// Copyright 2019 Setin Sergei
+// Licensed under the Apache License, Version 2.0 (the "License")
+
+package ice
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+// testOggPage - Ogg page with one packet, crc isn't checked by the server
+func testOggPage(bos bool, granule int64, serial uint32, packet []byte) []byte {
+	page := make([]byte, cOggHeaderSize, cOggHeaderSize+len(packet)/255+1+len(packet))
+	copy(page, oggCapture)
+	if bos {
+		page[5] = cOggFlagBOS
+	}
+	binary.LittleEndian.PutUint64(page[6:14], uint64(granule))
+	binary.LittleEndian.PutUint32(page[14:18], serial)
+	for n := len(packet); ; n -= 255 {
+		if n < 255 {
+			page = append(page, byte(n))
+			break
+		}
+		page = append(page, 255)
+	}
+	page[26] = byte(len(page) - cOggHeaderSize)
+	return append(page, packet...)
+}
+
+func TestOggStream(t *testing.T) {
+	opusHead := []byte("OpusHead\x01\x02\x38\x01\x80\xbb\x00\x00\x00\x00\x00")
+	head := testOggPage(true, 0, 1, opusHead)
+	tags := testOggPage(false, 0, 1, append([]byte("OpusTags"), make([]byte, 300)...))
+	var data []byte
+	data = append(data, "garbage"...)
+	data = append(data, head...)
+	data = append(data, tags...)
+	// 20 ms packets
+	for i := 1; i <= 3; i++ {
+		data = append(data, testOggPage(false, int64(i*960), 1, make([]byte, 100))...)
+	}
+	// chained stream
+	head2 := testOggPage(true, 0, 2, opusHead)
+	data = append(data, head2...)
+	data = append(data, testOggPage(false, 0, 2, []byte("OpusTags"))...)
+	data = append(data, testOggPage(false, 960, 2, make([]byte, 100))...)
+	data = append(data, head[:10]...)
+
+	var s oggStream
+	s.reset(true)
+	var headers, audio int
+	var positions []int64
+	// split in the middle of pages
+	for _, part := range [][]byte{data[:40], data[40:500], data[500:]} {
+		s.write(part)
+		for {
+			_, p, pos, ok := s.next()
+			if !ok {
+				break
+			}
+			if p.header {
+				headers++
+				continue
+			}
+			audio++
+			positions = append(positions, pos)
+			if p.audio.duration() != 20*time.Millisecond || p.audio.channels != 2 {
+				t.Errorf("page at %d: %+v", pos, p.audio)
+			}
+		}
+		if audio == 3 && !bytes.Equal(s.header(), append(head, tags...)) {
+			t.Errorf("wrong headers of the first stream: %d bytes", len(s.header()))
+		}
+	}
+	if headers != 4 || audio != 4 || positions[0] != int64(7+len(head)+len(tags)) {
+		t.Errorf("got %d header and %d audio pages at %v", headers, audio, positions)
+	}
+	if !bytes.HasPrefix(s.header(), head2) {
+		t.Error("headers of the chained stream are not kept")
+	}
+}
@@ -0,0 +1,181 @@
+// Copyright 2019 Setin Sergei
+// Licensed under the Apache License, Version 2.0 (the "License")
+
+package ice
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	iceclient "github.com/ssetin/PenguinCast/src/client"
+)
+
+const (
+	// delays between reconnections to upstream server
+	cRelayMinBackoff = time.Second
+	cRelayMaxBackoff = time.Minute
+	// how long the first listener of on-demand relay waits for the stream
+	cRelayWaitTimeOut = 5 * time.Second
+	cRelayWaitStep    = 100 * time.Millisecond
+	// how long on-demand relay stays connected after the last listener has left
+	cRelayGracePeriod = 30 * time.Second
+)
+
+// states of relay mount
+const (
+	cRelayIdle       = "idle"
+	cRelayConnecting = "connecting"
+	cRelayConnected  = "connected"
+)
+
+// startRelay - starts pulling the stream of relay mount from upstream, restarts relay if its options were changed.
+// On-demand relay is started only if it has listeners
+func (m *mount) startRelay() {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if m.RelayOnDemand && m.relayDemand == 0 {
+		m.haltRelay()
+		return
+	}
+	if m.relayStop != nil && m.relayURL == m.RelayURL && m.relayMetadata == m.RelayMetadata {
+		return
+	}
+	m.haltRelay()
+	if m.RelayURL == "" {
+		return
+	}
+	m.relayStop = make(chan struct{})
+	m.relayURL = m.RelayURL
+	m.relayMetadata = m.RelayMetadata
+	m.State.Relay = cRelayConnecting
+	go m.relay(m.relayURL, m.relayMetadata, m.relayStop)
+}
+
+// stopRelay - disconnects relay mount from upstream
+func (m *mount) stopRelay() {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.haltRelay()
+}
+
+// haltRelay - stops running relay, mount has to be locked
+func (m *mount) haltRelay() {
+	if m.relayTimer != nil {
+		m.relayTimer.Stop()
+		m.relayTimer = nil
+	}
+	if m.relayStop != nil {
+		close(m.relayStop)
+		m.relayStop = nil
+	}
+	m.State.Relay = ""
+	if m.RelayURL > "" {
+		m.State.Relay = cRelayIdle
+	}
+}
+
+// setRelayState - sets state of the relay, if it is still running
+func (m *mount) setRelayState(stop chan struct{}, state string) {
+	m.mux.Lock()
+	if m.relayStop == stop {
+		m.State.Relay = state
+	}
+	m.mux.Unlock()
+}
+
+// demandRelay - connects on-demand relay for a new listener and waits briefly for the stream.
+// Returns false if the mount is not an on-demand relay, otherwise releaseRelay has to be called, when listener leaves
+func (m *mount) demandRelay() bool {
+	m.mux.Lock()
+	if !m.RelayOnDemand || m.RelayURL == "" {
+		m.mux.Unlock()
+		return false
+	}
+	m.relayDemand++
+	if m.relayTimer != nil {
+		m.relayTimer.Stop()
+		m.relayTimer = nil
+	}
+	m.mux.Unlock()
+
+	m.startRelay()
+	for wait := time.Duration(0); !m.isStreaming() && wait < cRelayWaitTimeOut; wait += cRelayWaitStep {
+		time.Sleep(cRelayWaitStep)
+	}
+	return true
+}
+
+// releaseRelay - disconnects on-demand relay after grace period, if the last listener has left
+func (m *mount) releaseRelay() {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.relayDemand--
+	if m.relayDemand > 0 || m.relayStop == nil {
+		return
+	}
+	grace := cRelayGracePeriod
+	if m.RelayGracePeriod > 0 {
+		grace = time.Duration(m.RelayGracePeriod) * time.Second
+	}
+	var timer *time.Timer
+	timer = time.AfterFunc(grace, func() {
+		m.mux.Lock()
+		defer m.mux.Unlock()
+		// timer could be replaced, while it was waiting for the lock
+		if m.relayTimer == timer && m.relayDemand == 0 {
+			m.logger.Info("Relay of %s has no listeners", m.Name)
+			m.haltRelay()
+		}
+	})
+	m.relayTimer = timer
+}
+
+func (m *mount) relay(relayURL string, icyMeta bool, stop chan struct{}) {
+	client := &iceclient.PenguinClient{}
+	if err := client.InitURL(relayURL, icyMeta); err != nil {
+		m.logger.Error("Relay of %s: %s", m.Name, err.Error())
+		return
+	}
+	client.OnMeta(m.setMetaTitle)
+
+	m.logger.Info("Relay of %s from %s started", m.Name, relayURL)
+	client.Relay(stop, cRelayMinBackoff, cRelayMaxBackoff, func() error {
+		m.setRelayState(stop, cRelayConnected)
+		defer m.setRelayState(stop, cRelayConnecting)
+		return m.receiveRelay(client)
+	}, func(err error) {
+		m.logger.Error("Relay of %s: %s", m.Name, err.Error())
+	})
+	m.logger.Info("Relay of %s from %s stopped", m.Name, relayURL)
+}
+
+// receiveRelay - writes stream of connected upstream to the mount
+func (m *mount) receiveRelay(client *iceclient.PenguinClient) error {
+	conn := client.Conn()
+	if conn == nil {
+		// relay was stopped just after connection
+		return errors.New("relay is stopped")
+	}
+	header := http.Header{}
+	header.Set("Content-Type", client.Header("Content-Type"))
+	if client.BitRate() > 0 {
+		header.Set("ice-bitrate", strconv.Itoa(client.BitRate()))
+	}
+	for _, name := range []string{"Genre", "Description"} {
+		value := client.Header("Ice-" + name)
+		if value == "" {
+			value = client.Header("Icy-" + name)
+		}
+		if value == "" {
+			value = client.Header("X-Audiocast-" + name)
+		}
+		header.Set("ice-"+name, value)
+	}
+
+	if !m.receiveStream(conn, header, "RELAY", client) {
+		return errors.New("mount is busy by another source")
+	}
+	return errors.New("upstream disconnected")
+}
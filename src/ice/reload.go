@@ -0,0 +1,109 @@
+// Copyright 2019 Setin Sergei
+// Licensed under the Apache License, Version 2.0 (the "License")
+
+package ice
+
+import (
+	"sync/atomic"
+)
+
+// Reload - re-reads config and applies it to the running server without dropping listeners.
+// New mounts are created, removed ones are retired after their source disconnects,
+// limits and credentials are applied to live mounts
+func (i *Server) Reload() error {
+	var newOptions options
+	err := newOptions.Load(i.configFile)
+	if err != nil {
+		return err
+	}
+
+	i.reloadMux.Lock()
+	defer i.reloadMux.Unlock()
+
+	access, err := newAccessRules(newOptions.Access.accessOptions)
+	if err != nil {
+		return err
+	}
+
+	i.applyLimits(&newOptions)
+
+	i.mux.Lock()
+	i.Options.Name = newOptions.Name
+	i.Options.Admin = newOptions.Admin
+	i.Options.Location = newOptions.Location
+	i.Options.Auth = newOptions.Auth
+	i.Options.HLS = newOptions.HLS
+	restartRequired := i.Options.Host != newOptions.Host || i.Options.Socket != newOptions.Socket ||
+		i.Options.Paths != newOptions.Paths || i.Options.Logging != newOptions.Logging ||
+		i.Options.Access.BanFile != newOptions.Access.BanFile || i.Options.Master != newOptions.Master
+	i.Options.Access.accessOptions = newOptions.Access.accessOptions
+	i.access = access
+	i.mux.Unlock()
+
+	if restartRequired {
+		i.logger.Warning("Changes of Host, Socket, Paths, Logging, Access.BanFile and Master require restart")
+	}
+
+	current := i.Mounts()
+	configured := make(map[string]bool, len(newOptions.Mounts))
+
+	for _, nm := range newOptions.Mounts {
+		configured[nm.Name] = true
+		if m := i.findMount(current, nm.Name); m != nil {
+			m.update(nm)
+			m.startRelay()
+			continue
+		}
+		err = nm.Init(i, i.logger, i.poolManager)
+		if err != nil {
+			i.logger.Error("Mount %s: %s", nm.Name, err.Error())
+			continue
+		}
+		i.addMount(nm)
+		nm.startRelay()
+		i.logger.Log("Mount %s added", nm.Name)
+	}
+
+	for _, m := range current {
+		// mounts of master are managed by slave, dynamic ones are removed after their source
+		if configured[m.Name] || m.isFromMaster() || m.isDynamic() {
+			continue
+		}
+		retired := m.retire()
+		// relay disconnects as a source, so the mount is removed after that
+		m.stopRelay()
+		if retired {
+			i.removeMount(m)
+		} else {
+			i.logger.Log("Mount %s will be removed after its source disconnects", m.Name)
+		}
+	}
+
+	if err = i.initTemplates(newOptions.Templates); err != nil {
+		i.logger.Error("Templates: %s", err.Error())
+	}
+
+	i.logger.Log("Config reloaded")
+	return nil
+}
+
+func (i *Server) applyLimits(o *options) {
+	atomic.StoreInt32(&i.Options.Limits.Clients, o.Limits.Clients)
+	atomic.StoreInt32(&i.Options.Limits.Sources, o.Limits.Sources)
+	i.mux.Lock()
+	i.Options.Limits.SourceIdleTimeOut = o.Limits.SourceIdleTimeOut
+	i.Options.Limits.EmptyBufferIdleTimeOut = o.Limits.EmptyBufferIdleTimeOut
+	i.Options.Limits.WriteTimeOut = o.Limits.WriteTimeOut
+	i.Options.Limits.ConnectionsPerIP = o.Limits.ConnectionsPerIP
+	i.mux.Unlock()
+}
+
+// findMount - looks for mount by name including retired ones
+func (i *Server) findMount(mounts []*mount, name string) *mount {
+	for _, m := range mounts {
+		if m.Name == name {
+			return m
+		}
+	}
+	return nil
+}
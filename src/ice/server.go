@@ -5,6 +5,7 @@ package ice
 
 import (
 	"context"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -12,6 +13,7 @@ import (
 	"strings"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/ssetin/PenguinCast/src/log"
@@ -24,15 +26,22 @@ import (
 const (
 	cServerName = "PenguinCast"
 	cVersion    = "0.3.0dev"
+
+	cAuthTimeOut = 5 * time.Second
 )
 
 type Server struct {
+	// accessed atomically and kept first for 64-bit alignment
+	lastClientID uint64
+
 	serverName string
 	version    string
+	configFile string
 
 	Options options
 
 	mux            sync.Mutex
+	reloadMux      sync.Mutex
 	Started        int32
 	StartedTime    time.Time
 	ListenersCount int32
@@ -44,19 +53,29 @@ type Server struct {
 	memUsage int
 
 	srv         *http.Server
+	shoutcast   net.Listener
 	poolManager PoolManager
 	logger      Logger
+	// client of url auth backends
+	authClient *http.Client
+
+	access accessRules
+	bans   *banList
+	// number of connections by address
+	connections map[string]int
 }
 
-// Init - Load params from config.yaml
-func NewServer() (*Server, error) {
+// NewServer - Load params from configFile
+func NewServer(configFile string) (*Server, error) {
 	srv := &Server{
 		serverName:  cServerName,
 		version:     cVersion,
+		configFile:  configFile,
 		poolManager: pool.NewPoolManager(),
+		authClient:  &http.Client{Timeout: cAuthTimeOut},
 	}
 
-	err := srv.Options.Load()
+	err := srv.Options.Load(configFile)
 	if err != nil {
 		return nil, err
 	}
@@ -64,10 +83,25 @@ func NewServer() (*Server, error) {
 	if err != nil {
 		return nil, err
 	}
+	for _, o := range srv.Options.overridesList() {
+		srv.logger.Log("Config option %s", o)
+	}
+	srv.access, err = newAccessRules(srv.Options.Access.accessOptions)
+	if err != nil {
+		return nil, err
+	}
+	srv.bans, err = newBanList(srv.Options.Access.BanFile)
+	if err != nil {
+		return nil, err
+	}
 	err = srv.initMounts()
 	if err != nil {
 		return nil, err
 	}
+	err = srv.initTemplates(srv.Options.Templates)
+	if err != nil {
+		return nil, err
+	}
 
 	srv.logger.Log("%s %s", srv.serverName, srv.version)
 
@@ -88,14 +122,28 @@ func (i *Server) configureRouter() *mux.Router {
 	r := mux.NewRouter()
 	r.StrictSlash(true)
 
-	for _, mnt := range i.Options.Mounts {
-		r.HandleFunc("/"+mnt.Name, mnt.write).Methods("SOURCE", "PUT")
-		r.HandleFunc("/"+mnt.Name, mnt.read).Methods("GET")
-		r.Path("/admin/metadata").Queries("mode", "updinfo", "mount", "/"+mnt.Name).HandlerFunc(mnt.meta).Methods("GET")
-	}
+	// mounts could be added or removed in runtime, so they are matched dynamically
+	r.MatcherFunc(i.hlsMatcher).HandlerFunc(i.hlsHandler).Methods("GET")
+	r.MatcherFunc(i.sourceMatcher).HandlerFunc(i.sourceHandler).Methods("SOURCE", "PUT")
+	r.MatcherFunc(i.mountMatcher).HandlerFunc(i.listenerHandler).Methods("GET")
+	r.Path("/admin/metadata").Queries("mode", "updinfo").HandlerFunc(i.metaHandler).Methods("GET")
+	r.HandleFunc("/admin/reload", i.reloadHandler).Methods("GET", "POST")
+	r.HandleFunc("/admin/stats", i.statsHandler).Methods("GET")
+	r.HandleFunc("/admin/listmounts", i.listMountsHandler).Methods("GET")
+	r.HandleFunc("/admin/streamlist", i.listMountsHandler).Methods("GET")
+	r.HandleFunc("/admin/streamlist.txt", i.streamListHandler).Methods("GET")
+	r.HandleFunc("/admin/listclients", i.listClientsHandler).Methods("GET")
+	r.HandleFunc("/admin/killclient", i.killClientHandler).Methods("GET")
+	r.HandleFunc("/admin/killsource", i.killSourceHandler).Methods("GET")
+	r.HandleFunc("/admin/moveclients", i.moveClientsHandler).Methods("GET")
+	r.HandleFunc("/admin/bans", i.bansHandler).Methods("GET")
+	r.HandleFunc("/admin/ban", i.banHandler).Methods("GET")
+	r.HandleFunc("/admin/unban", i.unbanHandler).Methods("GET")
 
 	r.HandleFunc("/info", i.infoHandler).Methods("GET")
 	r.HandleFunc("/info.json", i.jsonHandler).Methods("GET")
+	r.HandleFunc("/status-json.xsl", i.jsonHandler).Methods("GET")
+	r.HandleFunc("/metrics", i.metricsHandler).Methods("GET")
 	if i.Options.Logging.UseMonitor {
 		r.HandleFunc("/monitor", i.monitorHandler).Methods("GET")
 		r.HandleFunc("/updateMonitor", i.updateMonitorHandler)
@@ -117,6 +165,73 @@ func (i *Server) initMounts() error {
 	return nil
 }
 
+// Mounts - returns current list of mounts
+func (i *Server) Mounts() []*mount {
+	i.mux.Lock()
+	defer i.mux.Unlock()
+	return i.Options.Mounts
+}
+
+// getMount - returns active mount by its name or path, nil if there is no such mount
+func (i *Server) getMount(name string) *mount {
+	name = strings.TrimPrefix(name, "/")
+	for _, m := range i.Mounts() {
+		if m.Name == name && !m.isRetired() {
+			return m
+		}
+	}
+	return nil
+}
+
+func (i *Server) addMount(m *mount) {
+	i.mux.Lock()
+	defer i.mux.Unlock()
+	// copy on write, so readers could range over old list without locking
+	mounts := make([]*mount, 0, len(i.Options.Mounts)+1)
+	mounts = append(mounts, i.Options.Mounts...)
+	i.Options.Mounts = append(mounts, m)
+}
+
+func (i *Server) removeMount(m *mount) {
+	i.mux.Lock()
+	mounts := make([]*mount, 0, len(i.Options.Mounts))
+	for _, t := range i.Options.Mounts {
+		if t != m {
+			mounts = append(mounts, t)
+		}
+	}
+	i.Options.Mounts = mounts
+	i.mux.Unlock()
+
+	m.Close()
+	i.logger.Log("Mount %s removed", m.Name)
+}
+
+func (i *Server) mountMatcher(r *http.Request, rm *mux.RouteMatch) bool {
+	return i.getMount(r.URL.Path) != nil
+}
+
+func (i *Server) sourceHandler(w http.ResponseWriter, r *http.Request) {
+	m := i.getMount(r.URL.Path)
+	if m == nil {
+		i.dynamicSource(w, r)
+		return
+	}
+	m.write(w, r)
+	if m.isDynamic() {
+		i.teardown(m)
+	}
+}
+
+func (i *Server) listenerHandler(w http.ResponseWriter, r *http.Request) {
+	m := i.getMount(r.URL.Path)
+	if m == nil {
+		http.NotFound(w, r)
+		return
+	}
+	m.read(w, r)
+}
+
 func (i *Server) incListeners() {
 	atomic.AddInt32(&i.ListenersCount, 1)
 }
@@ -135,6 +250,14 @@ func (i *Server) checkListeners() bool {
 	return true
 }
 
+// timeOuts - returns source idle, empty buffer idle and write timeouts, which could be changed by reload
+func (i *Server) timeOuts() (int, int, time.Duration) {
+	i.mux.Lock()
+	defer i.mux.Unlock()
+	return i.Options.Limits.SourceIdleTimeOut, i.Options.Limits.EmptyBufferIdleTimeOut,
+		time.Second * time.Duration(i.Options.Limits.WriteTimeOut)
+}
+
 func (i *Server) incSources() {
 	atomic.AddInt32(&i.SourcesCount, 1)
 }
@@ -161,9 +284,15 @@ func (i *Server) Close() {
 	} else {
 		i.logger.Log("Stopped")
 	}
+	i.mux.Lock()
+	if i.shoutcast != nil {
+		_ = i.shoutcast.Close()
+	}
+	i.mux.Unlock()
 
-	for idx := range i.Options.Mounts {
-		i.Options.Mounts[idx].Close()
+	for _, m := range i.Mounts() {
+		m.stopRelay()
+		m.Close()
 	}
 
 	i.statReader.Close()
@@ -171,16 +300,16 @@ func (i *Server) Close() {
 }
 
 //223.33.152.54 - - [27/Feb/2012:13:37:21 +0300] "GET /gop_aac HTTP/1.1" 200 75638 "-" "WMPlayer/10.0.0.364 guid/3300AD50-2C39-46C0-AE0A-AC7B8159E203" 400
-func (i Server) writeAccessLog(host string, startTime time.Time, request string, bytesSend int, refer, userAgent string, seconds int) {
+func (i *Server) writeAccessLog(host string, startTime time.Time, request string, bytesSend int, refer, userAgent string, seconds int) {
 	i.logger.Access("%s - - [%s] \"%s\" %s %d \"%s\" \"%s\" %d\r\n", host, startTime.Format(time.RFC1123Z), request, "200", bytesSend, refer, userAgent, seconds)
 }
 
-func (i Server) getHost(addr string) string {
-	idx := strings.Index(addr, ":")
-	if idx == -1 {
+func (i *Server) getHost(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
 		return addr
 	}
-	return addr[:idx]
+	return host
 }
 
 /*Start - start listening port ...*/
@@ -191,6 +320,8 @@ func (i *Server) Start() {
 
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, os.Interrupt, os.Kill)
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
 
 	go func() {
 		i.mux.Lock()
@@ -203,7 +334,27 @@ func (i *Server) Start() {
 			panic(err)
 		}
 	}()
+	if i.Options.Socket.Shoutcast {
+		go i.listenShoutcast()
+	}
+	for _, m := range i.Mounts() {
+		m.startRelay()
+	}
+	if i.Options.Master.URL > "" {
+		go i.slave()
+	}
 
-	<-stop
-	atomic.StoreInt32(&i.Started, 0)
+	for {
+		select {
+		case <-reload:
+			if err := i.Reload(); err != nil {
+				i.logger.Error("Config reload failed: %s", err.Error())
+				i.logger.Log("Config reload failed: %s", err.Error())
+			}
+		case <-stop:
+			signal.Stop(reload)
+			atomic.StoreInt32(&i.Started, 0)
+			return
+		}
+	}
 }
@@ -0,0 +1,135 @@
+// Copyright 2019 Setin Sergei
+// Licensed under the Apache License, Version 2.0 (the "License")
+
+package ice
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// time for shoutcast encoder to send its password and headers
+	cShoutcastHandshakeTimeOut = 10 * time.Second
+	// user of shoutcast sources in credentials files
+	cShoutcastUser = "source"
+)
+
+// listenShoutcast - accepts shoutcast v1 sources on Socket.Port+1
+func (i *Server) listenShoutcast() {
+	addr := ":" + strconv.Itoa(i.Options.Socket.Port+1)
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		i.logger.Error("Shoutcast listener: %s", err.Error())
+		return
+	}
+	i.mux.Lock()
+	i.shoutcast = ln
+	i.mux.Unlock()
+	i.logger.Log("Shoutcast sources are accepted on %s", addr)
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			if atomic.LoadInt32(&i.Started) == 1 {
+				i.logger.Error("Shoutcast listener: %s", err.Error())
+			}
+			return
+		}
+		go i.shoutcastSource(conn)
+	}
+}
+
+// shoutcastMount - returns mount the password belongs to. Password prefixed by mount name as "Name:password"
+// is checked against all credentials of the mount. Bare password is compared with plain text passwords only,
+// the first matching mount is used, so unauthenticated source couldn't make server hash it for every mount
+func (i *Server) shoutcastMount(password string) *mount {
+	if idx := strings.Index(password, ":"); idx > 0 {
+		if m := i.getMount(password[:idx]); m != nil && m.checkShoutcastPassword(password[idx+1:]) {
+			return m
+		}
+	}
+	for _, m := range i.Mounts() {
+		if !m.isRetired() && m.checkPlainPassword(password) {
+			return m
+		}
+	}
+	return nil
+}
+
+// shoutcastSource - performs shoutcast v1 handshake: password line, OK2, icy-* headers,
+// then writes stream of the source to the mount. Ultravox (shoutcast v2) sources are detected by their first bytes
+func (i *Server) shoutcastSource(conn net.Conn) {
+	defer conn.Close()
+	host := i.getHost(conn.RemoteAddr().String())
+
+	_ = conn.SetReadDeadline(time.Now().Add(cShoutcastHandshakeTimeOut))
+	reader := bufio.NewReader(conn)
+	if isUltravox(reader) {
+		i.ultravoxSource(conn, reader)
+		return
+	}
+
+	password, err := reader.ReadString('\n')
+	if err != nil {
+		i.logger.Error("Shoutcast source from %s: %s", host, err.Error())
+		return
+	}
+
+	m := i.shoutcastMount(strings.TrimRight(password, "\r\n"))
+	if m == nil {
+		i.logger.Error("Shoutcast source from %s: wrong password", host)
+		_, _ = conn.Write([]byte("invalid password\r\n"))
+		return
+	}
+	if err := i.admitSource(m, host); err != nil {
+		m.logger.Error("Shoutcast source of %s from %s: %s", m.Name, host, err.Error())
+		_, _ = conn.Write([]byte(err.Error() + "\r\n"))
+		return
+	}
+	defer i.releaseConnection(host)
+
+	_, err = conn.Write([]byte("OK2\r\nicy-caps:11\r\n\r\n"))
+	if err != nil {
+		m.logger.Error("Shoutcast source of %s: %s", m.Name, err.Error())
+		return
+	}
+	header, err := textproto.NewReader(reader).ReadMIMEHeader()
+	if err != nil {
+		m.logger.Error("Shoutcast source of %s: %s", m.Name, err.Error())
+		return
+	}
+	_ = conn.SetReadDeadline(time.Time{})
+
+	// shoutcast v1 streams are mp3
+	if header.Get("Content-Type") == "" {
+		header.Set("Content-Type", "audio/mpeg")
+	}
+	m.receiveStream(conn, http.Header(header), "ICY", reader)
+}
+
+// admitSource - checks if shoutcast source could be connected to the mount, counts its connection
+func (i *Server) admitSource(m *mount, host string) error {
+	if !i.checkSources() {
+		return errors.New("number of sources exceeded")
+	}
+	if err := m.admitClient(host, true); err != nil {
+		return err
+	}
+	if m.isStarted() {
+		i.releaseConnection(host)
+		return errors.New("source already connected")
+	}
+	return nil
+}
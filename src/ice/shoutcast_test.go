@@ -0,0 +1,114 @@
+// Copyright 2019 Setin Sergei
+// Licensed under the Apache License, Version 2.0 (the "License")
+
+package ice
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestShoutcastMount(t *testing.T) {
+	dir, err := ioutil.TempDir("", "penguin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	credentials := filepath.Join(dir, "credentials")
+	if err = ioutil.WriteFile(credentials, []byte("source:filepw\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := &Server{}
+	plain := &mount{Name: "plain", User: "admin", Password: "hackme", logger: nopLogger{}}
+	hashed := &mount{Name: "hashed", User: "admin", Password: string(hash), logger: nopLogger{}}
+	file := &mount{Name: "file", CredentialsFile: credentials, logger: nopLogger{}}
+	srv.Options.Mounts = []*mount{plain, hashed, file}
+
+	cases := []struct {
+		password string
+		want     *mount
+	}{
+		{"hackme", plain},
+		{"plain:hackme", plain},
+		// hashes and credentials files are checked only for the chosen mount
+		{"secret", nil},
+		{"hashed:secret", hashed},
+		{"filepw", nil},
+		{"file:filepw", file},
+		{"file:hackme", nil},
+		{"wrong", nil},
+	}
+	for _, c := range cases {
+		if got := srv.shoutcastMount(c.password); got != c.want {
+			t.Errorf("password %q gives mount %v, want %v", c.password, got, c.want)
+		}
+	}
+}
+
+func TestShoutcastSource(t *testing.T) {
+	srv := &Server{logger: nopLogger{}, bans: &banList{}, Started: 1}
+	srv.Options.Limits.Sources = 1
+	srv.Options.Limits.SourceIdleTimeOut = 1
+	m := &mount{Name: "live", User: "admin", Password: "hackme", BitRate: 128}
+	if err := m.Init(srv, nopLogger{}, testPools{}); err != nil {
+		t.Fatal(err)
+	}
+	srv.Options.Mounts = []*mount{m}
+
+	// handshake - password line, answer and icy headers
+	handshake := func(password string) (net.Conn, *bufio.Reader, chan struct{}) {
+		server, client := net.Pipe()
+		done := make(chan struct{})
+		go func() {
+			srv.shoutcastSource(server)
+			close(done)
+		}()
+		_ = client.SetDeadline(time.Now().Add(10 * time.Second))
+		_, _ = client.Write([]byte(password + "\r\n"))
+		return client, bufio.NewReader(client), done
+	}
+
+	client, reader, done := handshake("wrong")
+	if answer, _ := reader.ReadString('\n'); answer != "invalid password\r\n" {
+		t.Errorf("wrong password is answered %q", answer)
+	}
+	client.Close()
+	<-done
+
+	client, reader, done = handshake("live:hackme")
+	if answer, _ := reader.ReadString('\n'); answer != "OK2\r\n" {
+		t.Fatalf("right password is answered %q", answer)
+	}
+	for line := ""; line != "\r\n"; {
+		line, _ = reader.ReadString('\n')
+	}
+	_, _ = client.Write([]byte("icy-name:Live\r\nicy-br:96\r\n\r\nstream"))
+
+	for deadline := time.Now().Add(10 * time.Second); !m.isStarted() && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	// the second source of the mount isn't admitted
+	if err := srv.admitSource(m, "192.0.2.1"); err == nil || srv.connections["192.0.2.1"] != 0 {
+		t.Errorf("second source is admitted: %v, connections %v", err, srv.connections)
+	}
+	client.Close()
+	<-done
+	if m.buffer.Total() != int64(len("stream")) {
+		t.Errorf("mount got %d bytes", m.buffer.Total())
+	}
+	if err := srv.admitSource(m, "192.0.2.1"); err != nil {
+		t.Errorf("source isn't admitted after disconnection of the previous one: %v", err)
+	}
+}
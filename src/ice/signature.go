@@ -0,0 +1,76 @@
+// Copyright 2019 Setin Sergei
+// Licensed under the Apache License, Version 2.0 (the "License")
+
+package ice
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// signature - HMAC-SHA256 of mount name, expiry time and optionally client's ip
+func signature(secret, mountName string, expires int64, ip string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write([]byte(mountName + "\n" + strconv.FormatInt(expires, 10)))
+	if ip > "" {
+		_, _ = mac.Write([]byte("\n" + ip))
+	}
+	return mac.Sum(nil)
+}
+
+// SignURL - adds exp and sig parameters to the stream url, so listener could use it until expires.
+// If ip is not empty, url is valid only for that client address
+func SignURL(streamURL, secret string, expires time.Time, ip string) (string, error) {
+	u, err := url.Parse(streamURL)
+	if err != nil {
+		return "", err
+	}
+	mountName := strings.Trim(u.Path, "/")
+	if mountName == "" {
+		return "", errors.New("url has no mount name")
+	}
+	exp := expires.Unix()
+
+	query := u.Query()
+	query.Set("exp", strconv.FormatInt(exp, 10))
+	query.Set("sig", hex.EncodeToString(signature(secret, mountName, exp, ip)))
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// checkSignature - checks exp and sig parameters of listener's request, if mount requires signed urls
+func (m *mount) checkSignature(r *http.Request, ip string) error {
+	m.mux.Lock()
+	secret, bindIP := m.SignSecret, m.SignBindIP
+	m.mux.Unlock()
+	if secret == "" {
+		return nil
+	}
+
+	query := r.URL.Query()
+	exp, err := strconv.ParseInt(query.Get("exp"), 10, 64)
+	if err != nil {
+		return errors.New("url is not signed")
+	}
+	sig, err := hex.DecodeString(query.Get("sig"))
+	if err != nil || len(sig) == 0 {
+		return errors.New("url is not signed")
+	}
+	if !bindIP {
+		ip = ""
+	}
+	if !hmac.Equal(sig, signature(secret, m.Name, exp, ip)) {
+		return errors.New("wrong url signature")
+	}
+	if time.Now().Unix() > exp {
+		return errors.New("url is expired")
+	}
+	return nil
+}
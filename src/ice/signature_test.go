@@ -0,0 +1,43 @@
+// Copyright 2019 Setin Sergei
+// Licensed under the Apache License, Version 2.0 (the "License")
+
+package ice
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignedURL(t *testing.T) {
+	m := &mount{Name: "RockRadio96", SignSecret: "secret", SignBindIP: true}
+	sign := func(secret string, ttl time.Duration, ip string) string {
+		signed, err := SignURL("http://localhost:8008/RockRadio96", secret, time.Now().Add(ttl), ip)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+	valid := sign("secret", time.Minute, "10.0.0.1")
+
+	tests := []struct {
+		name    string
+		url     string
+		ip      string
+		wantErr bool
+	}{
+		{"valid", valid, "10.0.0.1", false},
+		{"other ip", valid, "10.0.0.2", true},
+		{"not signed", "http://localhost:8008/RockRadio96", "10.0.0.1", true},
+		{"expired", sign("secret", -time.Minute, "10.0.0.1"), "10.0.0.1", true},
+		{"wrong secret", sign("other", time.Minute, "10.0.0.1"), "10.0.0.1", true},
+		{"tampered expiry", strings.Replace(valid, "exp=", "exp=1", 1), "10.0.0.1", true},
+	}
+	for _, tt := range tests {
+		err := m.checkSignature(httptest.NewRequest("GET", tt.url, nil), tt.ip)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: got error %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright 2019 Setin Sergei
+// Licensed under the Apache License, Version 2.0 (the "License")
+
+package ice
+
+import (
+	"bufio"
+	"errors"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// slave - polls stream list of master server and mirrors its mounts as relay mounts
+func (i *Server) slave() {
+	i.mux.Lock()
+	master := i.Options.Master
+	i.mux.Unlock()
+
+	ticker := time.NewTicker(time.Duration(master.UpdateInterval) * time.Second)
+	defer ticker.Stop()
+	for {
+		if err := i.syncMaster(master); err != nil {
+			i.logger.Error("Master %s: %s", master.URL, err.Error())
+		}
+		<-ticker.C
+		//check, if server has to be stopped
+		if atomic.LoadInt32(&i.Started) == 0 {
+			return
+		}
+	}
+}
+
+// fetchStreamList - returns names of master's mounts with connected sources
+func (i *Server) fetchStreamList(master masterOptions) ([]string, error) {
+	req, err := http.NewRequest("GET", strings.TrimRight(master.URL, "/")+"/admin/streamlist.txt", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(master.User, master.Password)
+	resp, err := i.authClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("stream list request failed with " + resp.Status)
+	}
+
+	var names []string
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		name := strings.TrimPrefix(strings.TrimSpace(scanner.Text()), "/")
+		if name > "" {
+			names = append(names, name)
+		}
+	}
+	return names, scanner.Err()
+}
+
+// syncMaster - creates relay mounts for new streams of master and removes ones, which master doesn't have anymore.
+// Mounts from config are left as is
+func (i *Server) syncMaster(master masterOptions) error {
+	names, err := i.fetchStreamList(master)
+	if err != nil {
+		return err
+	}
+
+	i.reloadMux.Lock()
+	defer i.reloadMux.Unlock()
+
+	current := i.Mounts()
+	listed := make(map[string]bool, len(names))
+	for _, name := range names {
+		listed[name] = true
+		if i.findMount(current, name) != nil {
+			continue
+		}
+		if msg := checkMountName(name); msg > "" {
+			i.logger.Error("Master %s: %s", master.URL, msg)
+			continue
+		}
+		m := &mount{
+			Name:          name,
+			BitRate:       master.BitRate,
+			BurstSize:     master.BurstSize,
+			BurstDuration: master.BurstDuration,
+			RelayURL:      strings.TrimRight(master.URL, "/") + "/" + name,
+			RelayMetadata: master.RelayMetadata,
+			RelayOnDemand: master.RelayOnDemand,
+			fromMaster:    true,
+		}
+		if err = m.Init(i, i.logger, i.poolManager); err != nil {
+			i.logger.Error("Mount %s: %s", name, err.Error())
+			continue
+		}
+		i.addMount(m)
+		m.startRelay()
+		i.logger.Log("Mount %s added from master", name)
+	}
+
+	for _, m := range current {
+		if listed[m.Name] || !m.isFromMaster() || m.isRetired() {
+			continue
+		}
+		retired := m.retire()
+		m.stopRelay()
+		if retired {
+			i.removeMount(m)
+		} else {
+			i.logger.Log("Mount %s was removed from master, it will be removed after its relay disconnects", m.Name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+// Copyright 2019 Setin Sergei
+// Licensed under the Apache License, Version 2.0 (the "License")
+
+package ice
+
+import (
+	"fmt"
+)
+
+// startParsing - prepares parsing of the new source stream, parsers are set up when its codec is detected
+func (m *mount) startParsing() {
+	m.probe = m.probe[:0]
+	m.probeOffset = m.buffer.Total()
+	m.probing = true
+	m.setFormat(audioFormat{})
+	m.setStreamHeader(nil)
+}
+
+// setCodec - sets up parsers for the detected codec, the declared one is used if codec is unknown.
+// Content type is corrected, if source declares another codec
+func (m *mount) setCodec(codec string) {
+	m.mux.Lock()
+	declared := m.ContentType
+	if codec == "" {
+		codec = codecOf(declared)
+	} else if declaredAs(codec) != codecOf(declared) {
+		m.ContentType = codecContentTypes[codec]
+	}
+	contentType := m.ContentType
+	m.mux.Unlock()
+	if contentType != declared {
+		m.logger.Warning("Mount %s: source declares %s, but sends %s stream", m.Name, declared, codec)
+	}
+
+	m.server.mux.Lock()
+	hls := m.server.Options.HLS
+	m.server.mux.Unlock()
+	m.hls.start(contentType, hls.SegmentDuration, hls.Segments)
+
+	m.frames.reset(frameParserFor(codec))
+	m.frames.offset = m.probeOffset
+	m.ogg.reset(declaredAs(codec) == codecOgg)
+	m.ogg.offset = m.probeOffset
+	m.flac.reset(codec == codecFLAC)
+	m.flac.offset = m.probeOffset
+	m.format.reset(codec)
+	m.buffer.SetFramed(m.frames.parse != nil || m.ogg.active || m.flac.active, m.probeOffset)
+}
+
+// splitFrames - marks frames of the received data in the buffer, so listeners start from them, and passes
+// them to HLS segmenter. Ogg streams are split into pages, listeners start from audio ones
+func (m *mount) splitFrames(data []byte) {
+	if m.probing {
+		m.probe = append(m.probe, data...)
+		codec := detectCodec(m.probe)
+		if codec == "" && len(m.probe) < cMaxProbeSize {
+			return
+		}
+		m.probing = false
+		m.setCodec(codec)
+		data = m.probe
+	}
+
+	switch {
+	case m.ogg.active:
+		m.splitOggPages(data)
+	case m.flac.active:
+		m.splitFLACFrames(data)
+	case m.frames.parse != nil:
+		m.splitAudioFrames(data)
+	}
+}
+
+func (m *mount) splitAudioFrames(data []byte) {
+	m.frames.write(data)
+	found := false
+	for {
+		frame, f, pos, ok := m.frames.next()
+		if !ok {
+			break
+		}
+		found = true
+		m.format.add(f)
+		m.buffer.MarkFrame(pos, f.duration())
+		m.hls.write(frame, f)
+	}
+	if found {
+		m.setFormat(m.format.format)
+	}
+}
+
+func (m *mount) splitOggPages(data []byte) {
+	m.ogg.write(data)
+	found := false
+	for {
+		_, p, pos, ok := m.ogg.next()
+		if !ok {
+			break
+		}
+		if p.header {
+			m.setStreamHeader(m.ogg.header())
+			continue
+		}
+		found = true
+		m.format.add(p.audio)
+		m.buffer.MarkFrame(pos, p.audio.duration())
+	}
+	if found {
+		m.setFormat(m.format.format)
+	}
+}
+
+func (m *mount) splitFLACFrames(data []byte) {
+	m.flac.write(data)
+	found := false
+	for {
+		f, pos, header, ok := m.flac.next()
+		if !ok {
+			break
+		}
+		if header {
+			m.setStreamHeader(m.flac.header())
+			continue
+		}
+		found = true
+		m.format.add(f)
+		m.buffer.MarkFrame(pos, f.duration())
+	}
+	if found {
+		m.setFormat(m.format.format)
+	}
+}
+
+func (m *mount) setFormat(format audioFormat) {
+	m.mux.Lock()
+	m.State.Format = format
+	m.mux.Unlock()
+}
+
+// audioInfo - returns detected format for ice-audio-info header, empty string if it's unknown
+func (m *mount) audioInfo() string {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	format := m.State.Format
+	if format.Codec == "" || format.SampleRate == 0 {
+		return ""
+	}
+	return fmt.Sprintf("codec=%s;channels=%d;samplerate=%d", format.Codec, format.Channels, format.SampleRate)
+}
+
+func (m *mount) setStreamHeader(header []byte) {
+	m.mux.Lock()
+	m.streamHeader = header
+	m.mux.Unlock()
+}
+
+// getStreamHeader - returns data, which has to be sent to the listener before the stream
+func (m *mount) getStreamHeader() []byte {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	return m.streamHeader
+}
@@ -0,0 +1,329 @@
+// Copyright 2019 Setin Sergei
+// Licensed under the Apache License, Version 2.0 (the "License")
+
+package ice
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/xtea"
+)
+
+// Ultravox 2.1 message: sync byte 0x5A, reserved byte, type (class in the high 4 bits),
+// payload length, payload and 0x00 terminator
+const (
+	cUvoxSync       = 0x5A
+	cUvoxHeaderSize = 6
+	cUvoxMaxPayload = 16377
+
+	uvoxAuthenticate   = 0x1001
+	uvoxSetup          = 0x1002
+	uvoxBufferSize     = 0x1003
+	uvoxStandby        = 0x1004
+	uvoxTerminate      = 0x1005
+	uvoxMaxPayloadSize = 0x1008
+	uvoxCipherKey      = 0x1009
+	uvoxMimeType       = 0x1040
+	uvoxIcyName        = 0x1100
+	uvoxIcyGenre       = 0x1101
+	uvoxIcyURL         = 0x1102
+	uvoxIcyPub         = 0x1103
+	uvoxXMLMetadata    = 0x3902
+	uvoxMP3Data        = 0x7000
+	uvoxAACLCData      = 0x8001
+	uvoxAACPData       = 0x8003
+
+	// class of broadcaster negotiation messages
+	uvoxClassBroadcaster = 0x1
+)
+
+var errUvoxTerminated = errors.New("broadcast terminated by source")
+
+// isUltravox - detects ultravox source by its first bytes
+func isUltravox(reader *bufio.Reader) bool {
+	b, err := reader.Peek(2)
+	return err == nil && b[0] == cUvoxSync && b[1] == 0
+}
+
+func readUvoxMessage(reader *bufio.Reader) (uint16, []byte, error) {
+	var header [cUvoxHeaderSize]byte
+	if _, err := io.ReadFull(reader, header[:]); err != nil {
+		return 0, nil, err
+	}
+	if header[0] != cUvoxSync {
+		return 0, nil, errors.New("ultravox message is out of sync")
+	}
+	msgType := binary.BigEndian.Uint16(header[2:4])
+	payload := make([]byte, binary.BigEndian.Uint16(header[4:6]))
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return 0, nil, err
+	}
+	if end, err := reader.ReadByte(); err != nil || end != 0 {
+		return 0, nil, errors.New("ultravox message has no terminator")
+	}
+	return msgType, payload, nil
+}
+
+func writeUvoxMessage(w io.Writer, msgType uint16, payload string) error {
+	msg := make([]byte, cUvoxHeaderSize, cUvoxHeaderSize+len(payload)+1)
+	msg[0] = cUvoxSync
+	binary.BigEndian.PutUint16(msg[2:4], msgType)
+	binary.BigEndian.PutUint16(msg[4:6], uint16(len(payload)))
+	msg = append(msg, payload...)
+	msg = append(msg, 0)
+	_, err := w.Write(msg)
+	return err
+}
+
+// uvoxDecrypt - decrypts hex encoded XTEA cipher text of authentication message
+func uvoxDecrypt(cipherKey, text string) (string, error) {
+	// key is zero padded up to 16 bytes
+	key := make([]byte, 16)
+	copy(key, cipherKey)
+	cipher, err := xtea.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+	data, err := hex.DecodeString(text)
+	if err != nil || len(data)%xtea.BlockSize != 0 {
+		return "", errors.New("wrong cipher text")
+	}
+	for idx := 0; idx < len(data); idx += xtea.BlockSize {
+		cipher.Decrypt(data[idx:], data[idx:])
+	}
+	return string(bytes.TrimRight(data, "\x00")), nil
+}
+
+// ultravoxMount - returns mount, which StreamID is sid
+func (i *Server) ultravoxMount(sid int) *mount {
+	for _, m := range i.Mounts() {
+		m.mux.Lock()
+		streamID := m.StreamID
+		m.mux.Unlock()
+		if streamID > 0 && streamID == sid && !m.isRetired() {
+			return m
+		}
+	}
+	return nil
+}
+
+// ultravoxAuth - checks authentication message "2.1:sid:uid:password", uid and password are encrypted
+func (i *Server) ultravoxAuth(payload string) (*mount, error) {
+	parts := strings.Split(payload, ":")
+	if len(parts) != 4 {
+		return nil, errors.New("wrong authentication message")
+	}
+	sid, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return nil, errors.New("wrong stream id " + parts[1])
+	}
+	m := i.ultravoxMount(sid)
+	if m == nil {
+		return nil, errors.New("no mount for stream id " + parts[1])
+	}
+	i.mux.Lock()
+	cipherKey := i.Options.Auth.UltravoxCipherKey
+	i.mux.Unlock()
+	password, err := uvoxDecrypt(cipherKey, parts[3])
+	if err != nil || !m.checkShoutcastPassword(password) {
+		return nil, errors.New("wrong password for stream id " + parts[1])
+	}
+	return m, nil
+}
+
+// ultravoxSource - negotiates broadcast with ultravox source and writes its stream to the mount
+func (i *Server) ultravoxSource(conn net.Conn, reader *bufio.Reader) {
+	host := i.getHost(conn.RemoteAddr().String())
+	header := http.Header{}
+	var m *mount
+
+	for {
+		msgType, payload, err := readUvoxMessage(reader)
+		if err != nil {
+			i.logger.Error("Ultravox source from %s: %s", host, err.Error())
+			return
+		}
+
+		answer := "ACK"
+		switch msgType {
+		case uvoxCipherKey:
+			i.mux.Lock()
+			answer = "ACK:" + i.Options.Auth.UltravoxCipherKey
+			i.mux.Unlock()
+		case uvoxAuthenticate:
+			if m != nil {
+				break
+			}
+			m, err = i.ultravoxAuth(string(payload))
+			if err == nil {
+				err = i.admitSource(m, host)
+			}
+			if err != nil {
+				i.logger.Error("Ultravox source from %s: %s", host, err.Error())
+				_ = writeUvoxMessage(conn, msgType, "NAK:2.1:Deny")
+				return
+			}
+			defer i.releaseConnection(host)
+			answer = "ACK:2.1:Allow"
+		case uvoxSetup:
+			// average and maximum bitrate in kbps
+			header.Set("ice-bitrate", strings.Split(string(payload), ":")[0])
+		case uvoxBufferSize, uvoxMaxPayloadSize:
+			// desired and minimal sizes, payload could not exceed the limit of message length
+			size, _ := strconv.Atoi(strings.Split(string(payload), ":")[0])
+			if msgType == uvoxMaxPayloadSize && (size <= 0 || size > cUvoxMaxPayload) {
+				size = cUvoxMaxPayload
+			}
+			answer = "ACK:" + strconv.Itoa(size)
+		case uvoxMimeType:
+			header.Set("Content-Type", string(payload))
+		case uvoxIcyName:
+			header.Set("icy-name", string(payload))
+		case uvoxIcyGenre:
+			header.Set("icy-genre", string(payload))
+		case uvoxIcyURL:
+			header.Set("icy-url", string(payload))
+		case uvoxIcyPub:
+			header.Set("icy-pub", string(payload))
+		case uvoxTerminate:
+			return
+		}
+
+		if msgType == uvoxStandby && m == nil {
+			i.logger.Error("Ultravox source from %s: not authenticated", host)
+			_ = writeUvoxMessage(conn, msgType, "NAK:Not authenticated")
+			return
+		}
+		if msgType == uvoxStandby {
+			answer = "ACK:Data transfer mode"
+		}
+		if err = writeUvoxMessage(conn, msgType, answer); err != nil {
+			i.logger.Error("Ultravox source from %s: %s", host, err.Error())
+			return
+		}
+		if msgType == uvoxStandby {
+			break
+		}
+	}
+
+	_ = conn.SetReadDeadline(time.Time{})
+	if header.Get("Content-Type") == "" {
+		header.Set("Content-Type", "audio/mpeg")
+	}
+	m.receiveStream(conn, header, "ULTRAVOX/2.1", &ultravoxReader{
+		reader: bufio.NewReaderSize(reader, 64*1024),
+		conn:   conn,
+		m:      m,
+	})
+}
+
+// uvoxMetadata - fields of shoutcast XML metadata, which are used for stream title
+type uvoxMetadata struct {
+	Title  string `xml:"TIT2"`
+	Artist string `xml:"TPE1"`
+}
+
+// ultravoxReader - extracts audio data from ultravox messages of the source,
+// answers negotiation messages and applies metadata on the fly
+type ultravoxReader struct {
+	reader  *bufio.Reader
+	conn    net.Conn
+	m       *mount
+	pending []byte
+	err     error
+
+	// parts of metadata, which is split into several messages
+	metaID    uint16
+	metaParts [][]byte
+}
+
+// Read - returns data of all messages, which are already received, but at least one message
+func (u *ultravoxReader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if len(u.pending) > 0 {
+			c := copy(p[n:], u.pending)
+			u.pending = u.pending[c:]
+			n += c
+			continue
+		}
+		if u.err != nil || (n > 0 && u.reader.Buffered() == 0) {
+			break
+		}
+		msgType, payload, err := readUvoxMessage(u.reader)
+		if err != nil {
+			u.err = err
+			break
+		}
+		u.handle(msgType, payload)
+	}
+	if n == 0 && u.err != nil {
+		err := u.err
+		if err == io.EOF {
+			// let source idle timeout work as for other sources
+			u.err = nil
+		}
+		return 0, err
+	}
+	return n, nil
+}
+
+func (u *ultravoxReader) handle(msgType uint16, payload []byte) {
+	switch {
+	case msgType == uvoxMP3Data || msgType == uvoxAACLCData || msgType == uvoxAACPData:
+		u.pending = payload
+	case msgType == uvoxXMLMetadata:
+		u.metadata(payload)
+	case msgType == uvoxTerminate:
+		u.err = errUvoxTerminated
+	case msgType>>12 == uvoxClassBroadcaster:
+		_ = writeUvoxMessage(u.conn, msgType, "ACK")
+	}
+}
+
+// metadata - collects parts of XML metadata (id, span, index header and XML text) and updates stream title
+func (u *ultravoxReader) metadata(payload []byte) {
+	if len(payload) < 6 {
+		return
+	}
+	id := binary.BigEndian.Uint16(payload[0:2])
+	span := int(binary.BigEndian.Uint16(payload[2:4]))
+	index := int(binary.BigEndian.Uint16(payload[4:6]))
+	if id != u.metaID || index == 1 {
+		u.metaID = id
+		u.metaParts = u.metaParts[:0]
+	}
+	if index != len(u.metaParts)+1 {
+		// part is lost, wait for the next metadata
+		return
+	}
+	u.metaParts = append(u.metaParts, payload[6:])
+	if index < span {
+		return
+	}
+
+	var meta uvoxMetadata
+	if err := xml.Unmarshal(bytes.Join(u.metaParts, nil), &meta); err != nil {
+		u.m.logger.Error("Ultravox metadata of %s: %s", u.m.Name, err.Error())
+		return
+	}
+	u.metaParts = u.metaParts[:0]
+	title := strings.TrimSpace(meta.Title)
+	if artist := strings.TrimSpace(meta.Artist); artist > "" && title > "" {
+		title = artist + " - " + title
+	} else if artist > "" {
+		title = artist
+	}
+	u.m.setMetaTitle(title)
+}
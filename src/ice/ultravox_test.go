@@ -0,0 +1,116 @@
+// Copyright 2019 Setin Sergei
+// Licensed under the Apache License, Version 2.0 (the "License")
+
+package ice
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"golang.org/x/crypto/xtea"
+)
+
+func TestUvoxMessage(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeUvoxMessage(&buf, uvoxAuthenticate, "2.1:1:uid:password"); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeUvoxMessage(&buf, uvoxStandby, ""); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(buf.Bytes(), []byte{cUvoxSync, 0, 0x10, 0x01, 0, 18}) {
+		t.Errorf("wrong message header % x", buf.Bytes()[:cUvoxHeaderSize])
+	}
+
+	reader := bufio.NewReader(&buf)
+	msgType, payload, err := readUvoxMessage(reader)
+	if err != nil || msgType != uvoxAuthenticate || string(payload) != "2.1:1:uid:password" {
+		t.Errorf("got message %x %q, %v", msgType, payload, err)
+	}
+	msgType, payload, err = readUvoxMessage(reader)
+	if err != nil || msgType != uvoxStandby || len(payload) != 0 {
+		t.Errorf("got message %x %q, %v", msgType, payload, err)
+	}
+	if _, _, err = readUvoxMessage(reader); err != io.EOF {
+		t.Errorf("end of stream gives %v", err)
+	}
+
+	broken := [][]byte{
+		// out of sync
+		{0x5B, 0, 0x10, 0x01, 0, 1, 'a', 0},
+		// no terminator
+		{cUvoxSync, 0, 0x10, 0x01, 0, 1, 'a', 'b'},
+		// payload is shorter than its length
+		{cUvoxSync, 0, 0x10, 0x01, 0, 5, 'a'},
+	}
+	for _, msg := range broken {
+		if _, _, err := readUvoxMessage(bufio.NewReader(bytes.NewReader(msg))); err == nil {
+			t.Errorf("broken message % x is accepted", msg)
+		}
+	}
+}
+
+func TestUvoxDecrypt(t *testing.T) {
+	// key is padded by zeros, text by zeros up to the cipher block
+	key := make([]byte, 16)
+	copy(key, "foobar")
+	cipher, err := xtea.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, 16)
+	copy(data, "hackme123")
+	for idx := 0; idx < len(data); idx += xtea.BlockSize {
+		cipher.Encrypt(data[idx:], data[idx:])
+	}
+
+	if text, err := uvoxDecrypt("foobar", hex.EncodeToString(data)); err != nil || text != "hackme123" {
+		t.Errorf("got %q, %v", text, err)
+	}
+	if text, _ := uvoxDecrypt("wrongkey", hex.EncodeToString(data)); text == "hackme123" {
+		t.Error("text is decrypted by wrong key")
+	}
+	for _, text := range []string{"not hex", hex.EncodeToString(data[:5])} {
+		if _, err := uvoxDecrypt("foobar", text); err == nil {
+			t.Errorf("cipher text %q is accepted", text)
+		}
+	}
+}
+
+// testUvoxMetadata - part of XML metadata message
+func testUvoxMetadata(id, span, index int, xml string) string {
+	header := make([]byte, 6)
+	binary.BigEndian.PutUint16(header[0:2], uint16(id))
+	binary.BigEndian.PutUint16(header[2:4], uint16(span))
+	binary.BigEndian.PutUint16(header[4:6], uint16(index))
+	return string(header) + xml
+}
+
+func TestUltravoxReader(t *testing.T) {
+	xml := `<?xml version="1.0" encoding="UTF-8"?><metadata><TIT2>Song</TIT2><TPE1>Artist</TPE1></metadata>`
+	var stream bytes.Buffer
+	_ = writeUvoxMessage(&stream, uvoxMP3Data, "first")
+	// metadata is split into parts, audio goes between them
+	_ = writeUvoxMessage(&stream, uvoxXMLMetadata, testUvoxMetadata(1, 2, 1, xml[:40]))
+	_ = writeUvoxMessage(&stream, uvoxMP3Data, "second")
+	_ = writeUvoxMessage(&stream, uvoxXMLMetadata, testUvoxMetadata(1, 2, 2, xml[40:]))
+	// part of the next metadata is lost
+	_ = writeUvoxMessage(&stream, uvoxXMLMetadata, testUvoxMetadata(2, 2, 2, "<metadata><TIT2>Lost</TIT2></metadata>"))
+	_ = writeUvoxMessage(&stream, uvoxMP3Data, "third")
+	_ = writeUvoxMessage(&stream, uvoxTerminate, "")
+
+	m := &mount{Name: "uvox", logger: nopLogger{}}
+	u := &ultravoxReader{reader: bufio.NewReader(&stream), m: m}
+	data, err := ioutil.ReadAll(u)
+	if err != errUvoxTerminated || string(data) != "firstsecondthird" {
+		t.Errorf("got %q, %v", data, err)
+	}
+	if title := m.State.MetaInfo.StreamTitle; title != "Artist - Song" {
+		t.Errorf("stream title is %q", title)
+	}
+}
@@ -0,0 +1,362 @@
+// Copyright 2019 Setin Sergei
+// Licensed under the Apache License, Version 2.0 (the "License")
+
+package ice
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	pathpkg "path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// reservedNames - paths used by the server itself, which could not be mount names
+var reservedNames = []string{"admin", "info", "info.json", "metrics", "monitor", "status-json.xsl", "updateMonitor"}
+
+// configError - a problem found in config
+type configError struct {
+	location string
+	path     string
+	message  string
+}
+
+func (e configError) String() string {
+	return e.location + ": " + e.path + ": " + e.message
+}
+
+// configErrors - all problems found in config
+type configErrors []configError
+
+func (e configErrors) Error() string {
+	lines := make([]string, 0, len(e))
+	for _, ce := range e {
+		lines = append(lines, ce.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+type configValidator struct {
+	o      *options
+	errors configErrors
+}
+
+func (v *configValidator) add(path string, format string, args ...interface{}) {
+	v.errors = append(v.errors, configError{
+		location: v.o.location(path),
+		path:     path,
+		message:  fmt.Sprintf(format, args...),
+	})
+}
+
+func (v *configValidator) positive(path string, value int) {
+	if value <= 0 {
+		v.add(path, "must be greater than 0")
+	}
+}
+
+func (v *configValidator) notNegative(path string, value int) {
+	if value < 0 {
+		v.add(path, "must not be negative")
+	}
+}
+
+func (v *configValidator) bitRate(path string, value int) {
+	if value <= 0 || value > cMaxBitRate {
+		v.add(path, "must be in range 1..%d", cMaxBitRate)
+	}
+}
+
+func (v *configValidator) required(path string, value string) {
+	if value == "" {
+		v.add(path, "is required")
+	}
+}
+
+func (v *configValidator) dir(path string, value string) {
+	if value == "" {
+		return
+	}
+	info, err := os.Stat(value)
+	if err != nil {
+		v.add(path, "directory %s does not exist", value)
+		return
+	}
+	if !info.IsDir() {
+		v.add(path, "%s is not a directory", value)
+	}
+}
+
+// httpURL - checks that option is a valid http(s) url
+func (v *configValidator) httpURL(path string, value string) {
+	if value == "" {
+		return
+	}
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		v.add(path, "%s is not a valid http url", value)
+	}
+}
+
+// access - checks that access lists contain valid addresses and networks
+func (v *configValidator) access(path string, o accessOptions) {
+	lists := []struct {
+		name    string
+		entries []string
+	}{
+		{"ListenerAllow", o.ListenerAllow},
+		{"ListenerDeny", o.ListenerDeny},
+		{"SourceAllow", o.SourceAllow},
+		{"SourceDeny", o.SourceDeny},
+	}
+	for _, list := range lists {
+		for idx, entry := range list.entries {
+			if _, err := parseIPNet(entry); err != nil {
+				v.add(path+list.name+"."+strconv.Itoa(idx), "%s is not a valid ip address or network", entry)
+			}
+		}
+	}
+}
+
+// mountRef - checks that option refers to another defined mount
+func (v *configValidator) mountRef(path string, ref string, self string, names map[string]string) {
+	if ref == "" {
+		return
+	}
+	if ref == self {
+		v.add(path, "mount could not refer to itself")
+	} else if _, ok := names[ref]; !ok {
+		v.add(path, "mount %s is not defined", ref)
+	}
+}
+
+// validate - checks options and collects all problems found
+func (o *options) validate() error {
+	v := &configValidator{o: o}
+
+	if o.Socket.Port <= 0 || o.Socket.Port > 65535 {
+		v.add("Socket.Port", "must be between 1 and 65535")
+	} else if o.Socket.Shoutcast && o.Socket.Port == 65535 {
+		v.add("Socket.Shoutcast", "requires Socket.Port+1 to be a valid port")
+	}
+
+	v.positive("Limits.Clients", int(o.Limits.Clients))
+	v.positive("Limits.Sources", int(o.Limits.Sources))
+	v.positive("Limits.SourceIdleTimeOut", o.Limits.SourceIdleTimeOut)
+	v.positive("Limits.EmptyBufferIdleTimeOut", o.Limits.EmptyBufferIdleTimeOut)
+	v.positive("Limits.WriteTimeOut", o.Limits.WriteTimeOut)
+	v.notNegative("Limits.ConnectionsPerIP", o.Limits.ConnectionsPerIP)
+
+	v.access("Access.", o.Access.accessOptions)
+	if o.Access.BanFile > "" {
+		v.dir("Access.BanFile", filepath.Dir(o.Access.BanFile))
+	}
+
+	v.dir("Paths.Log", o.Paths.Log)
+	v.dir("Paths.Web", o.Paths.Web)
+
+	if o.Logging.LogLevel < 0 || o.Logging.LogLevel > 4 {
+		v.add("Logging.LogLevel", "must be between 0 and 4")
+	}
+	if o.Logging.UseMonitor {
+		v.positive("Logging.MonitorInterval", o.Logging.MonitorInterval)
+	}
+	if o.Logging.UseStat {
+		v.positive("Logging.StatInterval", o.Logging.StatInterval)
+	}
+
+	v.positive("HLS.SegmentDuration", o.HLS.SegmentDuration)
+	v.positive("HLS.Segments", o.HLS.Segments)
+
+	if o.Master.URL > "" {
+		v.httpURL("Master.URL", o.Master.URL)
+		if strings.HasPrefix(o.Master.URL, "https") {
+			v.add("Master.URL", "only http masters are supported")
+		}
+		v.required("Master.Password", o.Master.Password)
+		v.positive("Master.UpdateInterval", o.Master.UpdateInterval)
+		v.bitRate("Master.BitRate", o.Master.BitRate)
+		v.notNegative("Master.BurstSize", o.Master.BurstSize)
+		v.notNegative("Master.BurstDuration", o.Master.BurstDuration)
+	}
+
+	names := make(map[string]string, len(o.Mounts))
+	for idx, m := range o.Mounts {
+		path := "Mounts." + strconv.Itoa(idx)
+		if m == nil {
+			v.add(path, "mount is empty")
+			continue
+		}
+		m.validate(v, path)
+		if m.Name == "" {
+			continue
+		}
+		if prev, ok := names[m.Name]; ok {
+			v.add(path+".Name", "mount %s is already defined in %s", m.Name, prev)
+		} else {
+			names[m.Name] = path
+		}
+	}
+
+	for idx, t := range o.Templates {
+		path := "Templates." + strconv.Itoa(idx)
+		if t == nil {
+			v.add(path, "template is empty")
+			continue
+		}
+		t.validate(v, path)
+		if _, err := pathpkg.Match(t.Name, ""); err != nil {
+			v.add(path+".Name", "%s is not a valid pattern", t.Name)
+		}
+		if t.DumpFile > "" || t.RelayURL > "" || t.StreamID != 0 {
+			v.add(path, "DumpFile, RelayURL and StreamID could not be used in templates")
+		}
+		v.mountRef(path+".OverflowMount", t.OverflowMount, "", names)
+		v.mountRef(path+".FallbackMount", t.FallbackMount, "", names)
+	}
+
+	streamIDs := make(map[int]string, len(o.Mounts))
+	for idx, m := range o.Mounts {
+		if m == nil {
+			continue
+		}
+		path := "Mounts." + strconv.Itoa(idx)
+		v.mountRef(path+".OverflowMount", m.OverflowMount, m.Name, names)
+		v.mountRef(path+".FallbackMount", m.FallbackMount, m.Name, names)
+		if m.StreamID == 0 {
+			continue
+		}
+		if prev, ok := streamIDs[m.StreamID]; ok {
+			v.add(path+".StreamID", "stream id %d is already used by %s", m.StreamID, prev)
+		} else {
+			streamIDs[m.StreamID] = m.Name
+		}
+	}
+	if len(o.Auth.UltravoxCipherKey) > 16 {
+		v.add("Auth.UltravoxCipherKey", "must not be longer than 16 characters")
+	}
+
+	if len(v.errors) > 0 {
+		return v.errors
+	}
+	return nil
+}
+
+// checkMountName - returns the reason, why name could not be used for mount, or empty string
+func checkMountName(name string) string {
+	if strings.HasPrefix(name, "/") || strings.HasSuffix(name, "/") || strings.ContainsAny(name, " \t?#") {
+		return name + " is not a valid mount name"
+	}
+	for _, reserved := range reservedNames {
+		if name == reserved || strings.HasPrefix(name, reserved+"/") {
+			return name + " is reserved by the server"
+		}
+	}
+	return ""
+}
+
+func (m *mount) validate(v *configValidator, path string) {
+	v.required(path+".Name", m.Name)
+	if msg := checkMountName(m.Name); msg > "" {
+		v.add(path+".Name", "%s", msg)
+	}
+	if m.CredentialsFile > "" {
+		if _, err := os.Stat(m.CredentialsFile); err != nil {
+			v.add(path+".CredentialsFile", "file %s does not exist", m.CredentialsFile)
+		}
+	}
+	if m.CredentialsFile > "" || m.RelayURL > "" || m.SourceAuthURL > "" {
+		if (m.User == "") != (m.Password == "") {
+			v.add(path+".Password", "User and Password have to be set both or none of them")
+		}
+	} else {
+		v.required(path+".User", m.User)
+		v.required(path+".Password", m.Password)
+	}
+	v.bitRate(path+".BitRate", m.BitRate)
+	v.notNegative(path+".BurstSize", m.BurstSize)
+	v.notNegative(path+".BurstDuration", m.BurstDuration)
+	v.notNegative(path+".MaxListeners", m.MaxListeners)
+	v.notNegative(path+".StreamID", m.StreamID)
+	if m.OverflowMount > "" && m.OverflowURL > "" {
+		v.add(path+".OverflowURL", "only one of OverflowMount and OverflowURL could be set")
+	}
+	v.httpURL(path+".OverflowURL", m.OverflowURL)
+	v.httpURL(path+".SourceAuthURL", m.SourceAuthURL)
+	v.httpURL(path+".ListenerAddURL", m.ListenerAddURL)
+	v.httpURL(path+".ListenerRemoveURL", m.ListenerRemoveURL)
+	v.access(path+".", m.accessOptions)
+	v.httpURL(path+".RelayURL", m.RelayURL)
+	if strings.HasPrefix(m.RelayURL, "https") {
+		v.add(path+".RelayURL", "only http upstreams are supported")
+	}
+	if m.RelayOnDemand && m.RelayURL == "" {
+		v.add(path+".RelayOnDemand", "requires RelayURL")
+	}
+	v.notNegative(path+".RelayGracePeriod", m.RelayGracePeriod)
+	if m.SignBindIP && m.SignSecret == "" {
+		v.add(path+".SignBindIP", "requires SignSecret")
+	}
+	if m.DumpFile > "" {
+		v.dir(path+".DumpFile", filepath.Dir(m.DumpFile))
+	}
+}
+
+// location - returns where the option was defined: environment variable or line of config file
+func (o *options) location(path string) string {
+	// elements of overridden lists are reported with the variable of the whole list
+	name := path
+	for {
+		if env, ok := o.overrides[name]; ok {
+			return env
+		}
+		idx := strings.LastIndex(name, ".")
+		if idx == -1 {
+			break
+		}
+		name = name[:idx]
+	}
+	if line := o.line(path); line > 0 {
+		return o.fileName + ":" + strconv.Itoa(line)
+	}
+	return o.fileName
+}
+
+// line - returns line number of the option in config file.
+// If option is missing, returns line of the nearest parent
+func (o *options) line(path string) int {
+	if o.root == nil || len(o.root.Content) == 0 {
+		return 0
+	}
+	node := o.root.Content[0]
+	line := node.Line
+
+	for _, name := range strings.Split(path, ".") {
+		var next *yaml.Node
+		switch node.Kind {
+		case yaml.MappingNode:
+			for idx := 0; idx+1 < len(node.Content); idx += 2 {
+				if node.Content[idx].Value == name {
+					line = node.Content[idx].Line
+					next = node.Content[idx+1]
+					break
+				}
+			}
+		case yaml.SequenceNode:
+			idx, err := strconv.Atoi(name)
+			if err == nil && idx >= 0 && idx < len(node.Content) {
+				next = node.Content[idx]
+				line = next.Line
+			}
+		}
+		if next == nil {
+			break
+		}
+		node = next
+	}
+	return line
+}
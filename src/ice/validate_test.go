@@ -0,0 +1,61 @@
+// Copyright 2019 Setin Sergei
+// Licensed under the Apache License, Version 2.0 (the "License")
+
+package ice
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConfigErrorLocations(t *testing.T) {
+	dir, err := ioutil.TempDir("", "penguin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	logDir := filepath.Join(dir, "missing")
+	fileName := filepath.Join(dir, "config.yaml")
+	config := `Socket:
+  Port: 8008
+Limits:
+  Clients: 10
+  Sources: 1
+  SourceIdleTimeOut: 10
+  EmptyBufferIdleTimeOut: 5
+  WriteTimeOut: 10
+Auth:
+  AdminPassword: admin
+Paths:
+  Log: ` + logDir + `
+Mounts:
+  - Name: live
+    User: source
+    Password: hackme
+    BitRate: 0
+  - Name: live
+    User: source
+    Password: hackme
+    BitRate: 128
+`
+	if err = ioutil.WriteFile(fileName, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var o options
+	err = o.Load(fileName)
+	if err == nil {
+		t.Fatal("wrong config is loaded")
+	}
+	want := []string{
+		fileName + ":12: Paths.Log: directory " + logDir + " does not exist",
+		fileName + ":17: Mounts.0.BitRate: must be in range 1..10000",
+		fileName + ":18: Mounts.1.Name: mount live is already defined in Mounts.0",
+	}
+	if err.Error() != strings.Join(want, "\n") {
+		t.Errorf("got errors:\n%s\nwant:\n%s", err.Error(), strings.Join(want, "\n"))
+	}
+}